@@ -0,0 +1,66 @@
+package auth
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+const (
+	// Codes tried before a challenge is invalid and the user has to log in again
+	MaxMFAChallengeAttempts = 5
+	// Wrong codes over all challenges of a user within mfaFailureWindow before their second step is locked
+	maxMFAUserFailures = 20
+	mfaFailureWindow   = time.Hour
+)
+
+var ErrTooManyMFAFailures = errors.New("too many wrong codes, try again later")
+
+// Token of a challenge for userId that passed the first login step, valid for ttl.
+// It is exchanged once for a session with their second factor, see user_mfa_challenges in sql/common/6_auth_tokens.sql
+func IssueMFAChallenge(db *sql.DB, userId string, ttl time.Duration) (token string, expiresAt time.Time, err error) {
+	token, err = newRandomToken()
+	if err != nil {
+		return "", expiresAt, err
+	}
+	expiresAt = time.Now().UTC().Add(ttl)
+	_, err = db.Exec(`INSERT INTO user_mfa_challenges (user_id, token_hash, expires_at) VALUES ($1, $2, $3)`,
+		userId, hashToken(token), expiresAt)
+	return token, expiresAt, err
+}
+
+// Counts an attempt at the challenge before its code is checked, so that concurrent guesses are limited too.
+// Returns the user of the challenge, ErrInvalidToken if it is used, expired or out of attempts,
+// and ErrTooManyMFAFailures if the user had too many wrong codes recently over all their challenges.
+func StartMFAAttempt(db *sql.DB, token string) (userId string, err error) {
+	err = db.QueryRow(`UPDATE user_mfa_challenges SET attempts = attempts + 1
+		WHERE token_hash = $1 AND used_at ISNULL AND attempts < $2 AND expires_at > timezone('utc', now())
+		RETURNING user_id`, hashToken(token), MaxMFAChallengeAttempts).Scan(&userId)
+	if err == sql.ErrNoRows {
+		return "", ErrInvalidToken
+	}
+	if err != nil {
+		return "", err
+	}
+	// attempts of used challenges include their correct code
+	var failures int
+	err = db.QueryRow(`SELECT COALESCE(sum(attempts), 0) - count(used_at) FROM user_mfa_challenges
+		WHERE user_id = $1 AND created_at > $2`, userId, time.Now().UTC().Add(-mfaFailureWindow)).Scan(&failures)
+	if err == nil && failures > maxMFAUserFailures {
+		err = ErrTooManyMFAFailures
+	}
+	return userId, err
+}
+
+// Marks the challenge as used after a correct code, ErrInvalidToken if it already was
+func ConsumeMFAChallenge(db *sql.DB, token string) error {
+	res, err := db.Exec(`UPDATE user_mfa_challenges SET used_at = timezone('utc', now())
+		WHERE token_hash = $1 AND used_at ISNULL`, hashToken(token))
+	if err != nil {
+		return err
+	}
+	if count, err := res.RowsAffected(); err != nil || count == 0 {
+		return ErrInvalidToken
+	}
+	return nil
+}
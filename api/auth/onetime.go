@@ -0,0 +1,47 @@
+package auth
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Purposes of one time tokens sent by email, see user_email_tokens in sql/common/6_auth_tokens.sql
+const (
+	VerifyEmailPurpose   = "verify_email"
+	ResetPasswordPurpose = "reset_password"
+)
+
+// Token for userId to use once for purpose within ttl, earlier unused tokens of the purpose are invalidated
+func IssueEmailToken(db *sql.DB, userId string, purpose string, ttl time.Duration) (string, error) {
+	token, err := newRandomToken()
+	if err != nil {
+		return "", err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return "", err
+	}
+	_, err = tx.Exec(`UPDATE user_email_tokens SET used_at = timezone('utc', now())
+		WHERE user_id = $1 AND purpose = $2 AND used_at ISNULL`, userId, purpose)
+	if err == nil {
+		_, err = tx.Exec(`INSERT INTO user_email_tokens (user_id, purpose, token_hash, expires_at)
+			VALUES ($1, $2, $3, $4)`, userId, purpose, hashToken(token), time.Now().UTC().Add(ttl))
+	}
+	if err != nil {
+		_ = tx.Rollback()
+		return "", err
+	}
+	return token, tx.Commit()
+}
+
+// Marks an unexpired token of purpose as used and returns its user, ErrInvalidToken if there is none
+func ConsumeEmailToken(db *sql.DB, token string, purpose string) (userId string, err error) {
+	err = db.QueryRow(`UPDATE user_email_tokens SET used_at = timezone('utc', now())
+		WHERE token_hash = $1 AND purpose = $2 AND used_at ISNULL
+		AND expires_at > timezone('utc', now())
+		RETURNING user_id`, hashToken(token), purpose).Scan(&userId)
+	if err == sql.ErrNoRows {
+		return "", ErrInvalidToken
+	}
+	return userId, err
+}
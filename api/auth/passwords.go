@@ -0,0 +1,42 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	MinPasswordLength = 10
+	// bcrypt ignores bytes past 72
+	MaxPasswordLength = 72
+)
+
+var ErrWrongPassword = errors.New("wrong email or password")
+
+// Compared against when there is no user, so that unknown emails take as long as wrong passwords
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("dummy password"), bcrypt.DefaultCost)
+
+func ValidatePassword(password string) error {
+	if len(password) < MinPasswordLength || len(password) > MaxPasswordLength {
+		return fmt.Errorf("password must be %d to %d characters", MinPasswordLength, MaxPasswordLength)
+	}
+	return nil
+}
+
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	return string(hash), err
+}
+
+// ErrWrongPassword unless password matches hash, an empty hash never matches
+func CheckPassword(hash string, password string) error {
+	if hash == "" {
+		_ = bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(password))
+		return ErrWrongPassword
+	}
+	if bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) != nil {
+		return ErrWrongPassword
+	}
+	return nil
+}
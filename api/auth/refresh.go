@@ -0,0 +1,159 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"groupbuying.online/api/structs"
+	"time"
+)
+
+var ErrRevokedToken = errors.New("revoked token")
+
+// Refresh tokens are random and only their sha256 is stored, see sql/common/6_auth_tokens.sql
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func newRandomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// Refresh token for userId valid for ttl, starting a new family of rotated tokens on the client's device
+func IssueRefreshToken(db *sql.DB, userId string, userAgent string, ip string, ttl time.Duration) (token string,
+	expiresAt time.Time, err error) {
+	return insertRefreshToken(db, userId, nil, userAgent, ip, ttl)
+}
+
+type queryRower interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+func insertRefreshToken(db queryRower, userId string, familyId interface{}, userAgent string, ip string,
+	ttl time.Duration) (string, time.Time, error) {
+	token, err := newRandomToken()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	expiresAt := time.Now().UTC().Add(ttl)
+	var id string
+	err = db.QueryRow(`INSERT INTO refresh_tokens (user_id, token_hash, family_id, expires_at, device, user_agent, ip)
+		VALUES ($1, $2, COALESCE($3::uuid, uuid_generate_v4()), $4, $5, $6, $7) RETURNING id`,
+		userId, hashToken(token), familyId, expiresAt, DescribeDevice(userAgent), userAgent, ip).Scan(&id)
+	return token, expiresAt, err
+}
+
+// Exchanges a refresh token for a new one of the same family, each token can be used once.
+// Reusing a token revokes its whole family, as either the client or an attacker has a stolen copy,
+// and returns ErrRevokedToken with the user of the family. The new token has the device of the rotating client.
+func RotateRefreshToken(db *sql.DB, token string, userAgent string, ip string, ttl time.Duration) (userId string,
+	newToken string, expiresAt time.Time, err error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return "", "", expiresAt, err
+	}
+	var familyId string
+	var used, revoked, expired bool
+	err = tx.QueryRow(`SELECT user_id, family_id, used_at NOTNULL, revoked_at NOTNULL,
+		expires_at < timezone('utc', now())
+		FROM refresh_tokens WHERE token_hash = $1 FOR UPDATE`,
+		hashToken(token)).Scan(&userId, &familyId, &used, &revoked, &expired)
+	switch {
+	case err == sql.ErrNoRows:
+		err = ErrInvalidToken
+	case err != nil:
+	case used && !revoked:
+		_, err = tx.Exec(`UPDATE refresh_tokens SET revoked_at = timezone('utc', now())
+			WHERE family_id = $1 AND revoked_at ISNULL`, familyId)
+		if err == nil {
+			// keep the revocation
+			err = tx.Commit()
+			if err == nil {
+				err = ErrRevokedToken
+			}
+			return userId, "", expiresAt, err
+		}
+	case revoked:
+		err = ErrRevokedToken
+	case expired:
+		err = ErrExpiredToken
+	}
+	if err == nil {
+		newToken, expiresAt, err = insertRefreshToken(tx, userId, familyId, userAgent, ip, ttl)
+	}
+	if err == nil {
+		_, err = tx.Exec(`UPDATE refresh_tokens SET used_at = timezone('utc', now())
+			WHERE token_hash = $1`, hashToken(token))
+	}
+	if err != nil {
+		_ = tx.Rollback()
+		return "", "", expiresAt, err
+	}
+	return userId, newToken, expiresAt, tx.Commit()
+}
+
+// Revokes the family of a refresh token, returning its user
+func RevokeRefreshToken(db *sql.DB, token string) (userId string, err error) {
+	err = db.QueryRow(`WITH revoked AS (
+			UPDATE refresh_tokens SET revoked_at = COALESCE(revoked_at, timezone('utc', now()))
+			WHERE family_id = (SELECT family_id FROM refresh_tokens WHERE token_hash = $1)
+			RETURNING user_id
+		) SELECT DISTINCT user_id FROM revoked`, hashToken(token)).Scan(&userId)
+	if err == sql.ErrNoRows {
+		return "", ErrInvalidToken
+	}
+	return userId, err
+}
+
+// Logged in bearer clients of userId as sessions with the family id, last refreshed first.
+// A family is active while its latest token is unused, unrevoked and unexpired,
+// its device and ip are of the latest refresh and its last seen time is when that happened.
+func ListRefreshTokenFamilies(db *sql.DB, userId string) ([]structs.UserSession, error) {
+	rows, err := db.Query(`SELECT t.family_id, t.user_id, t.device, t.user_agent, t.ip,
+		(SELECT min(f.created_at) FROM refresh_tokens f WHERE f.family_id = t.family_id), t.created_at
+		FROM refresh_tokens t
+		WHERE t.user_id = $1 AND t.used_at ISNULL AND t.revoked_at ISNULL AND t.expires_at > timezone('utc', now())
+		ORDER BY t.created_at DESC`, userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	families := []structs.UserSession{}
+	for rows.Next() {
+		family, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		family.Bearer = true
+		families = append(families, family)
+	}
+	return families, rows.Err()
+}
+
+// Revokes a refresh token family of userId by its id, ErrSessionNotFound if the user has no such active family
+func RevokeRefreshTokenFamily(db *sql.DB, userId string, familyId string) error {
+	res, err := db.Exec(`UPDATE refresh_tokens SET revoked_at = timezone('utc', now())
+		WHERE family_id = $1 AND user_id = $2 AND revoked_at ISNULL`, familyId, userId)
+	if err != nil {
+		return err
+	}
+	if revoked, _ := res.RowsAffected(); revoked == 0 {
+		return ErrSessionNotFound
+	}
+	return nil
+}
+
+// Revokes all refresh tokens of a user, e.g. when logging out everywhere
+func RevokeUserRefreshTokens(db *sql.DB, userId string) error {
+	_, err := db.Exec(`UPDATE refresh_tokens SET revoked_at = timezone('utc', now())
+		WHERE user_id = $1 AND revoked_at ISNULL`, userId)
+	return err
+}
@@ -0,0 +1,274 @@
+package auth
+
+import (
+	"database/sql"
+	"errors"
+	"github.com/google/uuid"
+	"groupbuying.online/api/structs"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrSessionNotFound = errors.New("session not found")
+
+// Key of the session token in the signed session cookie
+const CookieSessionKey = "sessionToken"
+
+// Only refresh last_seen_at once per interval instead of on every request
+const sessionTouchInterval = time.Minute
+
+// Server side cookie sessions. The cookie holds the token returned by Create,
+// so revoking a session logs its device out on the next request.
+type SessionStore interface {
+	// New session of userId, returning it with its token
+	Create(userId string, userAgent string, ip string) (session structs.UserSession, token string, err error)
+	// Active session of a token, ErrSessionNotFound if it was revoked or has been idle for too long
+	Get(token string) (structs.UserSession, error)
+	// Marks the session as seen from ip
+	Touch(session structs.UserSession, ip string) error
+	// Active sessions of userId, last seen first
+	List(userId string) ([]structs.UserSession, error)
+	// Revokes a session of userId by its id, ErrSessionNotFound if the user has no such active session
+	Revoke(userId string, sessionId string) error
+	// Revokes all sessions of userId, returning how many were active
+	RevokeAll(userId string) (int64, error)
+}
+
+// Short description of the device of a user agent, e.g. "Chrome on Android"
+func DescribeDevice(userAgent string) string {
+	ua := strings.ToLower(userAgent)
+	browser := "Unknown browser"
+	for _, b := range []struct{ token, name string }{
+		{"edg/", "Edge"},
+		{"opr/", "Opera"},
+		{"samsungbrowser", "Samsung Internet"},
+		{"firefox", "Firefox"},
+		{"chrome", "Chrome"},
+		{"safari", "Safari"},
+		{"okhttp", "Android app"},
+		{"cfnetwork", "iOS app"},
+	} {
+		if strings.Contains(ua, b.token) {
+			browser = b.name
+			break
+		}
+	}
+	os := "unknown device"
+	for _, o := range []struct{ token, name string }{
+		{"android", "Android"},
+		{"iphone", "iPhone"},
+		{"ipad", "iPad"},
+		{"windows", "Windows"},
+		{"mac os", "Mac"},
+		{"darwin", "Mac"},
+		{"cros", "Chrome OS"},
+		{"linux", "Linux"},
+	} {
+		if strings.Contains(ua, o.token) {
+			os = o.name
+			break
+		}
+	}
+	return browser + " on " + os
+}
+
+// Sessions in the user_sessions table, see sql/common/6_auth_tokens.sql
+type PostgresSessionStore struct {
+	db      *sql.DB
+	idleTTL time.Duration
+}
+
+// Sessions unused for idleTTL are no longer active
+func NewPostgresSessionStore(db *sql.DB, idleTTL time.Duration) *PostgresSessionStore {
+	return &PostgresSessionStore{db: db, idleTTL: idleTTL}
+}
+
+const sessionCols = `id, user_id, device, user_agent, ip, created_at, last_seen_at`
+
+func scanSession(row interface{ Scan(...interface{}) error }) (structs.UserSession, error) {
+	var s structs.UserSession
+	err := row.Scan(&s.ID, &s.UserID, &s.Device, &s.UserAgent, &s.IP, &s.CreatedAt, &s.LastSeenAt)
+	return s, err
+}
+
+func (s *PostgresSessionStore) Create(userId string, userAgent string, ip string) (structs.UserSession, string, error) {
+	token, err := newRandomToken()
+	if err != nil {
+		return structs.UserSession{}, "", err
+	}
+	session, err := scanSession(s.db.QueryRow(`INSERT INTO user_sessions
+		(user_id, token_hash, device, user_agent, ip) VALUES ($1, $2, $3, $4, $5)
+		RETURNING `+sessionCols, userId, hashToken(token), DescribeDevice(userAgent), userAgent, ip))
+	return session, token, err
+}
+
+func (s *PostgresSessionStore) Get(token string) (structs.UserSession, error) {
+	session, err := scanSession(s.db.QueryRow(`SELECT `+sessionCols+` FROM user_sessions
+		WHERE token_hash = $1 AND revoked_at ISNULL
+		AND last_seen_at > timezone('utc', now()) - make_interval(secs => $2)`,
+		hashToken(token), s.idleTTL.Seconds()))
+	if err == sql.ErrNoRows {
+		return session, ErrSessionNotFound
+	}
+	return session, err
+}
+
+func (s *PostgresSessionStore) Touch(session structs.UserSession, ip string) error {
+	if time.Since(session.LastSeenAt) < sessionTouchInterval && session.IP == ip {
+		return nil
+	}
+	_, err := s.db.Exec(`UPDATE user_sessions SET last_seen_at = timezone('utc', now()), ip = $2
+		WHERE id = $1`, session.ID, ip)
+	return err
+}
+
+func (s *PostgresSessionStore) List(userId string) ([]structs.UserSession, error) {
+	rows, err := s.db.Query(`SELECT `+sessionCols+` FROM user_sessions
+		WHERE user_id = $1 AND revoked_at ISNULL
+		AND last_seen_at > timezone('utc', now()) - make_interval(secs => $2)
+		ORDER BY last_seen_at DESC`, userId, s.idleTTL.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	sessions := []structs.UserSession{}
+	for rows.Next() {
+		session, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, rows.Err()
+}
+
+func (s *PostgresSessionStore) Revoke(userId string, sessionId string) error {
+	res, err := s.db.Exec(`UPDATE user_sessions SET revoked_at = timezone('utc', now())
+		WHERE id = $1 AND user_id = $2 AND revoked_at ISNULL`, sessionId, userId)
+	if err != nil {
+		return err
+	}
+	if revoked, _ := res.RowsAffected(); revoked == 0 {
+		return ErrSessionNotFound
+	}
+	return nil
+}
+
+func (s *PostgresSessionStore) RevokeAll(userId string) (int64, error) {
+	res, err := s.db.Exec(`UPDATE user_sessions SET revoked_at = timezone('utc', now())
+		WHERE user_id = $1 AND revoked_at ISNULL`, userId)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// Sessions kept in memory, for tests and running without a database
+type MemorySessionStore struct {
+	mu       sync.Mutex
+	idleTTL  time.Duration
+	sessions map[string]*memorySession // by token hash
+	now      func() time.Time
+}
+
+type memorySession struct {
+	session structs.UserSession
+	revoked bool
+}
+
+func NewMemorySessionStore(idleTTL time.Duration) *MemorySessionStore {
+	return &MemorySessionStore{
+		idleTTL:  idleTTL,
+		sessions: map[string]*memorySession{},
+		now:      func() time.Time { return time.Now().UTC() },
+	}
+}
+
+func (s *MemorySessionStore) isActive(m *memorySession) bool {
+	return !m.revoked && s.now().Sub(m.session.LastSeenAt) < s.idleTTL
+}
+
+func (s *MemorySessionStore) Create(userId string, userAgent string, ip string) (structs.UserSession, string, error) {
+	token, err := newRandomToken()
+	if err != nil {
+		return structs.UserSession{}, "", err
+	}
+	now := s.now()
+	session := structs.UserSession{
+		ID:         uuid.New().String(),
+		UserID:     userId,
+		Device:     DescribeDevice(userAgent),
+		UserAgent:  userAgent,
+		IP:         ip,
+		CreatedAt:  now,
+		LastSeenAt: now,
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sessions[hashToken(token)] = &memorySession{session: session}
+	return session, token, nil
+}
+
+func (s *MemorySessionStore) Get(token string) (structs.UserSession, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m, ok := s.sessions[hashToken(token)]
+	if !ok || !s.isActive(m) {
+		return structs.UserSession{}, ErrSessionNotFound
+	}
+	return m.session, nil
+}
+
+func (s *MemorySessionStore) Touch(session structs.UserSession, ip string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, m := range s.sessions {
+		if m.session.ID == session.ID {
+			m.session.LastSeenAt = s.now()
+			m.session.IP = ip
+		}
+	}
+	return nil
+}
+
+func (s *MemorySessionStore) List(userId string) ([]structs.UserSession, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sessions := []structs.UserSession{}
+	for _, m := range s.sessions {
+		if m.session.UserID == userId && s.isActive(m) {
+			sessions = append(sessions, m.session)
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].LastSeenAt.After(sessions[j].LastSeenAt)
+	})
+	return sessions, nil
+}
+
+func (s *MemorySessionStore) Revoke(userId string, sessionId string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, m := range s.sessions {
+		if m.session.ID == sessionId && m.session.UserID == userId && !m.revoked {
+			m.revoked = true
+			return nil
+		}
+	}
+	return ErrSessionNotFound
+}
+
+func (s *MemorySessionStore) RevokeAll(userId string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var revoked int64
+	for _, m := range s.sessions {
+		if m.session.UserID == userId && !m.revoked {
+			m.revoked = true
+			revoked++
+		}
+	}
+	return revoked, nil
+}
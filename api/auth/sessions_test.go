@@ -0,0 +1,116 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+func newTestMemoryStore(idleTTL time.Duration) (*MemorySessionStore, *time.Time) {
+	store := NewMemorySessionStore(idleTTL)
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	store.now = func() time.Time { return now }
+	return store, &now
+}
+
+func TestMemorySessionStoreGet(t *testing.T) {
+	store, _ := newTestMemoryStore(time.Hour)
+	session, token, err := store.Create("user-1", "Mozilla/5.0 (Linux; Android 10) Chrome/80.0", "10.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if session.Device != "Chrome on Android" {
+		t.Errorf("device = %q, want Chrome on Android", session.Device)
+	}
+	got, err := store.Get(token)
+	if err != nil || got.ID != session.ID || got.UserID != "user-1" {
+		t.Errorf("Get = %+v, %v, want session %s", got, err, session.ID)
+	}
+	if _, err = store.Get("other token"); err != ErrSessionNotFound {
+		t.Errorf("Get of unknown token = %v, want ErrSessionNotFound", err)
+	}
+}
+
+func TestMemorySessionStoreIdleExpiry(t *testing.T) {
+	store, now := newTestMemoryStore(time.Hour)
+	session, token, err := store.Create("user-1", "", "10.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	*now = now.Add(50 * time.Minute)
+	if _, err = store.Get(token); err != nil {
+		t.Fatalf("Get before idle ttl = %v", err)
+	}
+	if err = store.Touch(session, "10.0.0.2"); err != nil {
+		t.Fatal(err)
+	}
+	// touched sessions stay active for another idle ttl
+	*now = now.Add(50 * time.Minute)
+	got, err := store.Get(token)
+	if err != nil {
+		t.Fatalf("Get after touch = %v", err)
+	}
+	if got.IP != "10.0.0.2" {
+		t.Errorf("ip = %q, want the touched one", got.IP)
+	}
+
+	*now = now.Add(time.Hour)
+	if _, err = store.Get(token); err != ErrSessionNotFound {
+		t.Errorf("Get after idle ttl = %v, want ErrSessionNotFound", err)
+	}
+	if sessions, _ := store.List("user-1"); len(sessions) != 0 {
+		t.Errorf("List after idle ttl = %d sessions, want 0", len(sessions))
+	}
+}
+
+func TestMemorySessionStoreRevoke(t *testing.T) {
+	store, now := newTestMemoryStore(time.Hour)
+	first, firstToken, _ := store.Create("user-1", "", "")
+	*now = now.Add(time.Minute)
+	_, secondToken, _ := store.Create("user-1", "", "")
+
+	sessions, err := store.List("user-1")
+	if err != nil || len(sessions) != 2 {
+		t.Fatalf("List = %d sessions, %v, want 2", len(sessions), err)
+	}
+	if sessions[0].ID == first.ID {
+		t.Error("List is not ordered by last seen first")
+	}
+
+	if err = store.Revoke("user-2", first.ID); err != ErrSessionNotFound {
+		t.Errorf("Revoke of another user's session = %v, want ErrSessionNotFound", err)
+	}
+	if err = store.Revoke("user-1", first.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = store.Get(firstToken); err != ErrSessionNotFound {
+		t.Errorf("Get of revoked session = %v, want ErrSessionNotFound", err)
+	}
+	if _, err = store.Get(secondToken); err != nil {
+		t.Errorf("Get of other session = %v, want it active", err)
+	}
+	if err = store.Revoke("user-1", first.ID); err != ErrSessionNotFound {
+		t.Errorf("second Revoke = %v, want ErrSessionNotFound", err)
+	}
+}
+
+func TestMemorySessionStoreRevokeAll(t *testing.T) {
+	store, _ := newTestMemoryStore(time.Hour)
+	_, token, _ := store.Create("user-1", "", "")
+	_, _, _ = store.Create("user-1", "", "")
+	_, otherToken, _ := store.Create("user-2", "", "")
+
+	revoked, err := store.RevokeAll("user-1")
+	if err != nil || revoked != 2 {
+		t.Errorf("RevokeAll = %d, %v, want 2", revoked, err)
+	}
+	if _, err = store.Get(token); err != ErrSessionNotFound {
+		t.Errorf("Get after RevokeAll = %v, want ErrSessionNotFound", err)
+	}
+	if _, err = store.Get(otherToken); err != nil {
+		t.Errorf("Get of another user's session = %v, want it active", err)
+	}
+	if revoked, _ = store.RevokeAll("user-1"); revoked != 0 {
+		t.Errorf("second RevokeAll = %d, want 0", revoked)
+	}
+}
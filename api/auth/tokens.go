@@ -0,0 +1,115 @@
+// Package auth issues and verifies bearer access tokens and rotating refresh tokens for clients without cookies,
+// and stores cookie sessions server side.
+package auth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidToken = errors.New("invalid token")
+	ErrExpiredToken = errors.New("expired token")
+)
+
+type contextKey int
+
+const userIdKey contextKey = iota
+
+// HS256 JWT header, the only one accepted
+var jwtHeader = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+
+type accessClaims struct {
+	Subject   string `json:"sub"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+func sign(key string, signingInput string) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(signingInput))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Signed JWT for userId, valid for ttl
+func IssueAccessToken(key string, userId string, ttl time.Duration) (token string, expiresAt time.Time, err error) {
+	if key == "" {
+		return "", expiresAt, errors.New("no token key configured")
+	}
+	now := time.Now().UTC()
+	expiresAt = now.Add(ttl)
+	claims, err := json.Marshal(accessClaims{Subject: userId, IssuedAt: now.Unix(), ExpiresAt: expiresAt.Unix()})
+	if err != nil {
+		return "", expiresAt, err
+	}
+	signingInput := jwtHeader + "." + base64.RawURLEncoding.EncodeToString(claims)
+	return signingInput + "." + sign(key, signingInput), expiresAt, nil
+}
+
+// User id of a valid access token signed with key
+func ParseAccessToken(key string, token string) (userId string, err error) {
+	parts := strings.Split(token, ".")
+	if key == "" || len(parts) != 3 || parts[0] != jwtHeader {
+		return "", ErrInvalidToken
+	}
+	signingInput := parts[0] + "." + parts[1]
+	if !hmac.Equal([]byte(parts[2]), []byte(sign(key, signingInput))) {
+		return "", ErrInvalidToken
+	}
+	claimsJson, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", ErrInvalidToken
+	}
+	var claims accessClaims
+	if err = json.Unmarshal(claimsJson, &claims); err != nil || claims.Subject == "" {
+		return "", ErrInvalidToken
+	}
+	if time.Now().Unix() >= claims.ExpiresAt {
+		return "", ErrExpiredToken
+	}
+	return claims.Subject, nil
+}
+
+// Token of an `Authorization: Bearer` header, empty if there is none
+func BearerToken(r *http.Request) string {
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return ""
+	}
+	return strings.TrimSpace(strings.TrimPrefix(header, "Bearer "))
+}
+
+func HasBearerToken(r *http.Request) bool {
+	return strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ")
+}
+
+// Context with the authenticated user id, set by the auth middleware
+func WithUserId(ctx context.Context, userId string) context.Context {
+	return context.WithValue(ctx, userIdKey, userId)
+}
+
+func UserIdFromContext(ctx context.Context) (string, bool) {
+	userId, ok := ctx.Value(userIdKey).(string)
+	return userId, ok && userId != ""
+}
+
+// User id from the request context, or else from its bearer token.
+// found is false when the request has neither and the caller should use the cookie session,
+// a request with a bearer token is never authenticated by its cookie.
+func UserIdFromRequest(r *http.Request, key string) (userId string, found bool, err error) {
+	if userId, ok := UserIdFromContext(r.Context()); ok {
+		return userId, true, nil
+	}
+	if !HasBearerToken(r) {
+		return "", false, nil
+	}
+	userId, err = ParseAccessToken(key, BearerToken(r))
+	return userId, true, err
+}
@@ -0,0 +1,79 @@
+package auth
+
+import (
+	"encoding/base64"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testTokenKey = "test key"
+
+// Token with the given header and claims json, signed with testTokenKey
+func signTestToken(header string, claims string) string {
+	signingInput := base64.RawURLEncoding.EncodeToString([]byte(header)) + "." +
+		base64.RawURLEncoding.EncodeToString([]byte(claims))
+	return signingInput + "." + sign(testTokenKey, signingInput)
+}
+
+func TestParseAccessToken(t *testing.T) {
+	token, _, err := IssueAccessToken(testTokenKey, "user-1", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if userId, err := ParseAccessToken(testTokenKey, token); err != nil || userId != "user-1" {
+		t.Fatalf("ParseAccessToken = %q, %v, want user-1", userId, err)
+	}
+
+	parts := strings.Split(token, ".")
+	flipped := "A"
+	if parts[2][:1] == "A" {
+		flipped = "B"
+	}
+	tampered := parts[0] + "." + parts[1] + "." + flipped + parts[2][1:]
+	otherClaims := base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"user-2","exp":9999999999}`))
+	expiresAt := time.Now().Add(time.Minute).Unix()
+	validClaims := `{"sub":"user-1","exp":` + itoa(expiresAt) + `}`
+	tests := []struct {
+		name  string
+		key   string
+		token string
+		err   error
+	}{
+		{"tampered signature", testTokenKey, tampered, ErrInvalidToken},
+		{"claims of another user", testTokenKey, parts[0] + "." + otherClaims + "." + parts[2], ErrInvalidToken},
+		{"other key", "other key", token, ErrInvalidToken},
+		{"no key", "", token, ErrInvalidToken},
+		{"none alg", testTokenKey, signTestToken(`{"alg":"none","typ":"JWT"}`, validClaims), ErrInvalidToken},
+		{"HS512 alg", testTokenKey, signTestToken(`{"alg":"HS512","typ":"JWT"}`, validClaims), ErrInvalidToken},
+		{"unsigned", testTokenKey, parts[0] + "." + parts[1] + ".", ErrInvalidToken},
+		{"not a jwt", testTokenKey, "token", ErrInvalidToken},
+		{"empty subject", testTokenKey, signTestToken(`{"alg":"HS256","typ":"JWT"}`,
+			`{"sub":"","exp":`+itoa(expiresAt)+`}`), ErrInvalidToken},
+		{"no subject", testTokenKey, signTestToken(`{"alg":"HS256","typ":"JWT"}`,
+			`{"exp":`+itoa(expiresAt)+`}`), ErrInvalidToken},
+		{"expired", testTokenKey, signTestToken(`{"alg":"HS256","typ":"JWT"}`,
+			`{"sub":"user-1","exp":`+itoa(time.Now().Add(-time.Second).Unix())+`}`), ErrExpiredToken},
+		{"no expiry", testTokenKey, signTestToken(`{"alg":"HS256","typ":"JWT"}`, `{"sub":"user-1"}`), ErrExpiredToken},
+	}
+	for _, test := range tests {
+		if userId, err := ParseAccessToken(test.key, test.token); err != test.err {
+			t.Errorf("%s: ParseAccessToken = %q, %v, want %v", test.name, userId, err, test.err)
+		}
+	}
+}
+
+func TestIssuedAccessTokenExpires(t *testing.T) {
+	token, _, err := IssueAccessToken(testTokenKey, "user-1", -time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ParseAccessToken(testTokenKey, token); err != ErrExpiredToken {
+		t.Errorf("ParseAccessToken of an expired token = %v, want ErrExpiredToken", err)
+	}
+}
+
+func itoa(n int64) string {
+	return strconv.FormatInt(n, 10)
+}
@@ -0,0 +1,93 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+// ASCII "12345678901234567890", the SHA1 secret of the RFC 4226 and RFC 6238 test vectors
+const rfcTestSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestHOTP(t *testing.T) {
+	// RFC 4226 appendix D
+	want := []string{"755224", "287082", "359152", "969429", "338314", "254676", "287922", "162583", "399871", "520489"}
+	key, _ := totpEncoding.DecodeString(rfcTestSecret)
+	for counter, code := range want {
+		if got := hotp(key, uint64(counter)); got != code {
+			t.Errorf("hotp(%d) = %s, want %s", counter, got, code)
+		}
+	}
+}
+
+func TestVerifyTOTP(t *testing.T) {
+	// RFC 6238 appendix B, the last 6 of the 8 digit SHA1 codes
+	tests := []struct {
+		unix int64
+		code string
+		step int64
+	}{
+		{59, "287082", 1},
+		{1111111109, "081804", 37037036},
+		{1111111111, "050471", 37037037},
+		{1234567890, "005924", 41152263},
+		{2000000000, "279037", 66666666},
+		{20000000000, "353130", 666666666},
+	}
+	for _, test := range tests {
+		step, ok := VerifyTOTP(rfcTestSecret, test.code, time.Unix(test.unix, 0))
+		if !ok || step != test.step {
+			t.Errorf("VerifyTOTP(%s) at %d = %d, %v, want step %d", test.code, test.unix, step, ok, test.step)
+		}
+	}
+}
+
+func TestVerifyTOTPSkew(t *testing.T) {
+	// code of step 37037036, valid from 1111111080 to 1111111109
+	tests := []struct {
+		unix int64
+		ok   bool
+	}{
+		{1111111050, true}, // a step earlier
+		{1111111139, true}, // a step later
+		{1111111049, false},
+		{1111111140, false},
+	}
+	for _, test := range tests {
+		step, ok := VerifyTOTP(rfcTestSecret, "081804", time.Unix(test.unix, 0))
+		if ok != test.ok || (ok && step != 37037036) {
+			t.Errorf("VerifyTOTP at %d = %d, %v, want %v", test.unix, step, ok, test.ok)
+		}
+	}
+}
+
+// Callers reject codes of steps up to the last accepted one, so a code verified again keeps its step
+func TestVerifyTOTPStepReuse(t *testing.T) {
+	first, ok := VerifyTOTP(rfcTestSecret, "081804", time.Unix(1111111109, 0))
+	if !ok {
+		t.Fatal("first use rejected")
+	}
+	again, ok := VerifyTOTP(rfcTestSecret, "081804", time.Unix(1111111111, 0))
+	if !ok || again > first {
+		t.Errorf("reused code = step %d, %v, want at most the accepted step %d", again, ok, first)
+	}
+	next, ok := VerifyTOTP(rfcTestSecret, "050471", time.Unix(1111111111, 0))
+	if !ok || next <= first {
+		t.Errorf("next code = step %d, %v, want after the accepted step %d", next, ok, first)
+	}
+}
+
+func TestVerifyTOTPInvalidCodes(t *testing.T) {
+	now := time.Unix(59, 0)
+	for _, code := range []string{"", "28708", "2870820", "94287082", "287083"} {
+		if _, ok := VerifyTOTP(rfcTestSecret, code, now); ok {
+			t.Errorf("VerifyTOTP(%q) accepted", code)
+		}
+	}
+	if _, ok := VerifyTOTP("not base32!", "287082", now); ok {
+		t.Error("VerifyTOTP with an invalid secret accepted")
+	}
+	// spaces as shown by some authenticator apps, and a lower case secret
+	if _, ok := VerifyTOTP("gezdgnbvgy3tqojqgezdgnbvgy3tqojq", "287 082", now); !ok {
+		t.Error("VerifyTOTP of a spaced code rejected")
+	}
+}
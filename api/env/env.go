@@ -6,9 +6,11 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/gorilla/sessions"
+	"groupbuying.online/api/auth"
 	"groupbuying.online/api/structs"
 	"log"
 	"os"
+	"time"
 
 	_ "github.com/lib/pq"
 
@@ -20,6 +22,7 @@ var (
 	Conf *structs.Config
 	Db *sql.DB
 	Store *sessions.CookieStore
+	Sessions auth.SessionStore
 	Firebase *firebase.App
 )
 
@@ -58,6 +61,11 @@ func initDB() {
 func initSessionStore() {
 	key := []byte(Conf.SessionStoreKey)
 	Store = sessions.NewCookieStore(key)
+	idleDays := Conf.SessionIdleDays
+	if idleDays <= 0 {
+		idleDays = 30
+	}
+	Sessions = auth.NewPostgresSessionStore(Db, time.Duration(idleDays) * 24 * time.Hour)
 }
 
 func getConfiguration(configFolder string, envType string) (*structs.Config, error) {
@@ -78,8 +86,14 @@ func getConfiguration(configFolder string, envType string) (*structs.Config, err
 }
 
 
+// Firebase is optional, push notifications are disabled without its service account key
 func initFirebase(configFolder string, env string) {
-	opt := option.WithCredentialsFile(fmt.Sprintf("%s/%s-serviceAccountKey.json", configFolder, env))
+	keyFile := fmt.Sprintf("%s/%s-serviceAccountKey.json", configFolder, env)
+	if _, err := os.Stat(keyFile); os.IsNotExist(err) {
+		log.Printf("no %s, push notifications are disabled", keyFile)
+		return
+	}
+	opt := option.WithCredentialsFile(keyFile)
 	var err error
 	Firebase, err = firebase.NewApp(context.Background(), nil, opt)
 	if err != nil {
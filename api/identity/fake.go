@@ -0,0 +1,49 @@
+package identity
+
+import (
+	"context"
+	"strings"
+	"sync"
+)
+
+// In process provider for tests and local dev.
+// Tokens given to Register verify to their identity, any other token of the form
+// "fake|<subject>|<email>" or "fake|<subject>|<email>|<display name>" verifies to those claims.
+type FakeProvider struct {
+	name       string
+	mu         sync.Mutex
+	identities map[string]Identity
+}
+
+func NewFakeProvider(name string) *FakeProvider {
+	return &FakeProvider{name: name, identities: map[string]Identity{}}
+}
+
+func (p *FakeProvider) Name() string {
+	return p.name
+}
+
+func (p *FakeProvider) Register(token string, identity Identity) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	identity.Provider = p.name
+	p.identities[token] = identity
+}
+
+func (p *FakeProvider) Verify(ctx context.Context, token string) (Identity, error) {
+	p.mu.Lock()
+	identity, ok := p.identities[token]
+	p.mu.Unlock()
+	if ok {
+		return identity, nil
+	}
+	parts := strings.Split(token, "|")
+	if len(parts) < 3 || len(parts) > 4 || parts[0] != "fake" || parts[1] == "" {
+		return Identity{}, ErrInvalidToken
+	}
+	identity = Identity{Provider: p.name, Subject: parts[1], Email: parts[2], EmailVerified: parts[2] != ""}
+	if len(parts) == 4 {
+		identity.DisplayName = parts[3]
+	}
+	return identity, nil
+}
@@ -0,0 +1,91 @@
+package identity
+
+import (
+	"context"
+	"groupbuying.online/api/structs"
+	"os"
+	"testing"
+)
+
+func newFakeProviders() Providers {
+	return New(&structs.Config{Identity: structs.IdentityConfig{Fake: true}})
+}
+
+func TestNewFakeReplacesEveryProvider(t *testing.T) {
+	providers := newFakeProviders()
+	for _, name := range []string{"google", "facebook", "email"} {
+		if _, ok := providers[name].(*FakeProvider); !ok {
+			t.Errorf("provider %s = %T, want *FakeProvider", name, providers[name])
+		}
+	}
+}
+
+// Registering and later logging in with tokens of the same subject resolve to the same identity
+func TestFakeRegisterThenLogin(t *testing.T) {
+	providers := newFakeProviders()
+	ctx := context.Background()
+
+	registered, err := providers.Verify(ctx, "google", "fake|google-sub-1|Alice@Example.com|alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Identity{Provider: "google", Subject: "google-sub-1", Email: "alice@example.com",
+		EmailVerified: true, DisplayName: "alice"}
+	if registered != want {
+		t.Errorf("register claims = %+v, want %+v", registered, want)
+	}
+
+	loggedIn, err := providers.Verify(ctx, "google", "fake|google-sub-1|alice@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loggedIn.Provider != registered.Provider || loggedIn.Subject != registered.Subject ||
+		loggedIn.Email != registered.Email {
+		t.Errorf("login claims = %+v, want the registered identity %+v", loggedIn, registered)
+	}
+
+	// the same subject at another provider is another identity
+	other, err := providers.Verify(ctx, "facebook", "fake|google-sub-1|alice@example.com")
+	if err != nil || other.Provider != "facebook" {
+		t.Errorf("facebook claims = %+v, %v", other, err)
+	}
+}
+
+func TestFakeRegisteredToken(t *testing.T) {
+	providers := newFakeProviders()
+	fake := providers["facebook"].(*FakeProvider)
+	fake.Register("opaque-token", Identity{Subject: "fb-1", Email: "Bob@Example.com", DisplayName: "bob"})
+
+	claims, err := providers.Verify(context.Background(), "facebook", "opaque-token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Provider != "facebook" || claims.Subject != "fb-1" || claims.Email != "bob@example.com" {
+		t.Errorf("claims = %+v, want the registered identity with a lower cased email", claims)
+	}
+}
+
+func TestFakeInvalidTokens(t *testing.T) {
+	providers := newFakeProviders()
+	ctx := context.Background()
+	for _, token := range []string{"", "not a fake token", "fake||a@example.com", "fake|sub", "fake|sub|a@example.com|name|extra"} {
+		if _, err := providers.Verify(ctx, "google", token); err != ErrInvalidToken {
+			t.Errorf("Verify(%q) = %v, want ErrInvalidToken", token, err)
+		}
+	}
+	if _, err := providers.Verify(ctx, "twitter", "fake|sub|a@example.com"); err != ErrUnknownProvider {
+		t.Errorf("Verify of unknown provider = %v, want ErrUnknownProvider", err)
+	}
+}
+
+func TestNewRefusesFakeOutsideDev(t *testing.T) {
+	defer os.Setenv("ENV", os.Getenv("ENV"))
+	_ = os.Setenv("ENV", "prod")
+	providers := newFakeProviders()
+	if _, ok := providers["facebook"].(*FakeProvider); ok {
+		t.Error("facebook is fake with ENV=prod")
+	}
+	if _, err := providers.Verify(context.Background(), "google", "fake|sub-1|alice@example.com"); err != ErrUnknownProvider {
+		t.Errorf("Verify of a fake google token with ENV=prod = %v, want ErrUnknownProvider", err)
+	}
+}
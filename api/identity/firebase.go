@@ -0,0 +1,67 @@
+package identity
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+)
+
+const defaultFirebaseAuthURL = "https://identitytoolkit.googleapis.com/v1"
+
+// Verifies Firebase Auth ID tokens of email and password sign in with the Identity Toolkit REST API
+type FirebaseProvider struct {
+	client  *http.Client
+	authURL string
+	apiKey  string
+}
+
+func NewFirebaseProvider(client *http.Client, authURL string, apiKey string) *FirebaseProvider {
+	if authURL == "" {
+		authURL = defaultFirebaseAuthURL
+	}
+	return &FirebaseProvider{client: client, authURL: authURL, apiKey: apiKey}
+}
+
+func (p *FirebaseProvider) Name() string {
+	return "email"
+}
+
+// Looks up the account of the token, which fails for invalid, expired or revoked tokens
+func (p *FirebaseProvider) Verify(ctx context.Context, token string) (Identity, error) {
+	body, err := json.Marshal(map[string]string{"idToken": token})
+	if err != nil {
+		return Identity{}, err
+	}
+	req, err := http.NewRequest(http.MethodPost,
+		p.authURL+"/accounts:lookup?key="+url.QueryEscape(p.apiKey), bytes.NewReader(body))
+	if err != nil {
+		return Identity{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	var lookup struct {
+		Users []struct {
+			LocalID       string `json:"localId"`
+			Email         string `json:"email"`
+			EmailVerified bool   `json:"emailVerified"`
+			DisplayName   string `json:"displayName"`
+			PhotoURL      string `json:"photoUrl"`
+		} `json:"users"`
+	}
+	if err = doJson(ctx, p.client, req, &lookup); err != nil {
+		return Identity{}, err
+	}
+	if len(lookup.Users) != 1 || lookup.Users[0].LocalID == "" {
+		return Identity{}, ErrInvalidToken
+	}
+	user := lookup.Users[0]
+	return Identity{
+		Provider:      p.Name(),
+		Subject:       user.LocalID,
+		Email:         user.Email,
+		EmailVerified: user.EmailVerified,
+		DisplayName:   user.DisplayName,
+		ImageURL:      user.PhotoURL,
+	}, nil
+}
@@ -0,0 +1,67 @@
+package identity
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+)
+
+const defaultGoogleTokenInfoURL = "https://www.googleapis.com/oauth2/v3/tokeninfo"
+
+// Issuers of Google ID tokens
+var googleIssuers = []string{"accounts.google.com", "https://accounts.google.com"}
+
+// Verifies Google ID tokens with the tokeninfo endpoint
+type GoogleProvider struct {
+	client       *http.Client
+	tokenInfoURL string
+	clientIDs    []string
+}
+
+// Tokens must be issued by Google for one of clientIDs, none are accepted if empty
+func NewGoogleProvider(client *http.Client, tokenInfoURL string, clientIDs []string) *GoogleProvider {
+	if tokenInfoURL == "" {
+		tokenInfoURL = defaultGoogleTokenInfoURL
+	}
+	return &GoogleProvider{client: client, tokenInfoURL: tokenInfoURL, clientIDs: clientIDs}
+}
+
+func (p *GoogleProvider) Name() string {
+	return "google"
+}
+
+func (p *GoogleProvider) Verify(ctx context.Context, token string) (Identity, error) {
+	var info struct {
+		Subject       string   `json:"sub"`
+		Issuer        string   `json:"iss"`
+		Audience      string   `json:"aud"`
+		Email         string   `json:"email"`
+		EmailVerified flexBool `json:"email_verified"`
+		Name          string   `json:"name"`
+		Picture       string   `json:"picture"`
+	}
+	err := getJson(ctx, p.client, p.tokenInfoURL+"?id_token="+url.QueryEscape(token), &info)
+	if err != nil {
+		return Identity{}, err
+	}
+	if info.Subject == "" || !contains(googleIssuers, info.Issuer) || !contains(p.clientIDs, info.Audience) {
+		return Identity{}, ErrInvalidToken
+	}
+	return Identity{
+		Provider:      p.Name(),
+		Subject:       info.Subject,
+		Email:         info.Email,
+		EmailVerified: bool(info.EmailVerified),
+		DisplayName:   info.Name,
+		ImageURL:      info.Picture,
+	}, nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,58 @@
+package identity
+
+import (
+	"context"
+	"fmt"
+	"groupbuying.online/api/structs"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// Tokeninfo endpoint answering the claims of tokens named "<issuer> <audience>"
+func newTokenInfoServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var issuer, audience string
+		_, _ = fmt.Sscan(r.URL.Query().Get("id_token"), &issuer, &audience)
+		_, _ = fmt.Fprintf(w, `{"iss": %q, "aud": %q, "sub": "google-sub-1", "email": "alice@example.com",
+			"email_verified": "true"}`, issuer, audience)
+	}))
+}
+
+func TestGoogleProviderChecksIssuerAndAudience(t *testing.T) {
+	server := newTokenInfoServer()
+	defer server.Close()
+	provider := NewGoogleProvider(server.Client(), server.URL, []string{"our-app"})
+	tests := []struct {
+		token string
+		err   error
+	}{
+		{"accounts.google.com our-app", nil},
+		{"https://accounts.google.com our-app", nil},
+		{"accounts.google.com other-app", ErrInvalidToken},
+		{"https://evil.example.com our-app", ErrInvalidToken},
+	}
+	for _, test := range tests {
+		claims, err := provider.Verify(context.Background(), test.token)
+		if err != test.err {
+			t.Errorf("Verify(%q) = %v, want %v", test.token, err, test.err)
+		}
+		if err == nil && (claims.Subject != "google-sub-1" || !claims.EmailVerified) {
+			t.Errorf("Verify(%q) claims = %+v", test.token, claims)
+		}
+	}
+}
+
+func TestGoogleProviderWithoutClientIDs(t *testing.T) {
+	server := newTokenInfoServer()
+	defer server.Close()
+	provider := NewGoogleProvider(server.Client(), server.URL, nil)
+	if _, err := provider.Verify(context.Background(), "accounts.google.com any-app"); err != ErrInvalidToken {
+		t.Errorf("Verify without client ids = %v, want ErrInvalidToken", err)
+	}
+
+	providers := New(&structs.Config{})
+	if _, err := providers.Verify(context.Background(), "google", "token"); err != ErrUnknownProvider {
+		t.Errorf("Verify of unconfigured google = %v, want ErrUnknownProvider", err)
+	}
+}
@@ -0,0 +1,140 @@
+// Package identity verifies sign in tokens of Google, Facebook and Firebase,
+// with a fake provider to run the login and registration flows without network.
+package identity
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"google.golang.org/appengine"
+	"groupbuying.online/api/structs"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidToken    = errors.New("invalid sign in token")
+	ErrUnknownProvider = errors.New("unknown sign in provider")
+)
+
+// Claims of a verified sign in token
+type Identity struct {
+	Provider      string
+	Subject       string // stable user id at the provider
+	Email         string // lower cased, empty if the provider has none
+	EmailVerified bool
+	DisplayName   string
+	ImageURL      string
+}
+
+type Provider interface {
+	Name() string
+	// Claims of token, ErrInvalidToken if the provider rejects it
+	Verify(ctx context.Context, token string) (Identity, error)
+}
+
+// Providers by name, matching users.auth_type: "email" for Firebase email and password sign in,
+// "google" and "facebook"
+type Providers map[string]Provider
+
+// Google and Firebase are only used when their client ids and API key are configured,
+// passwords are handled by package auth. identity.fake is refused outside of local dev.
+func New(conf *structs.Config) Providers {
+	fake := conf.Identity.Fake
+	if fake && !isLocalDev() {
+		log.Printf("WARNING: identity.fake is only allowed in local dev, ignoring it")
+		fake = false
+	} else if fake {
+		log.Printf("WARNING: identity.fake is on, every sign in provider accepts fake tokens")
+	}
+	client := &http.Client{Timeout: 10 * time.Second}
+	providers := []Provider{
+		NewFacebookProvider(client, conf.Identity.FacebookGraphURL, conf.FBAppId, conf.FBAppSecret),
+	}
+	if len(conf.Identity.GoogleClientIDs) > 0 || fake {
+		providers = append(providers,
+			NewGoogleProvider(client, conf.Identity.GoogleTokenInfoURL, conf.Identity.GoogleClientIDs))
+	}
+	if conf.Identity.FirebaseAPIKey != "" || fake {
+		providers = append(providers,
+			NewFirebaseProvider(client, conf.Identity.FirebaseAuthURL, conf.Identity.FirebaseAPIKey))
+	}
+	if fake {
+		for i, provider := range providers {
+			providers[i] = NewFakeProvider(provider.Name())
+		}
+	}
+	byName := Providers{}
+	for _, provider := range providers {
+		byName[provider.Name()] = provider
+	}
+	return byName
+}
+
+// Not deployed and running with the dev config, see env.initConfig
+func isLocalDev() bool {
+	envType := os.Getenv("ENV")
+	return !appengine.IsAppEngine() && (envType == "" || envType == "dev")
+}
+
+func (p Providers) Verify(ctx context.Context, name string, token string) (Identity, error) {
+	provider, ok := p[name]
+	if !ok {
+		return Identity{}, ErrUnknownProvider
+	}
+	if token == "" {
+		return Identity{}, ErrInvalidToken
+	}
+	identity, err := provider.Verify(ctx, token)
+	identity.Email = strings.ToLower(identity.Email)
+	return identity, err
+}
+
+// Largest provider response read, they are small json objects
+const maxResponseBytes = 1 << 20
+
+// Sends the request and decodes a json response into out.
+// Client errors are ErrInvalidToken, as providers answer them for invalid or expired tokens.
+func doJson(ctx context.Context, client *http.Client, req *http.Request, out interface{}) error {
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_, _ = io.Copy(ioutil.Discard, resp.Body)
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode >= 400 && resp.StatusCode < 500 {
+		return ErrInvalidToken
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s responded %s", req.URL.Host, resp.Status)
+	}
+	if err = json.NewDecoder(io.LimitReader(resp.Body, maxResponseBytes)).Decode(out); err != nil {
+		return fmt.Errorf("invalid response from %s: %s", req.URL.Host, err)
+	}
+	return nil
+}
+
+func getJson(ctx context.Context, client *http.Client, url string, out interface{}) error {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	return doJson(ctx, client, req, out)
+}
+
+// Booleans that some endpoints send as strings, e.g. "email_verified": "true"
+type flexBool bool
+
+func (b *flexBool) UnmarshalJSON(data []byte) error {
+	s := strings.Trim(string(data), `"`)
+	*b = flexBool(s == "true")
+	return nil
+}
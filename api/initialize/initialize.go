@@ -5,10 +5,6 @@ import (
 	"groupbuying.online/api/routes"
 )
 
-
-// TODO: Implement:
-// TODO: - rate limit middleware
-
 func Init() {
 	env.InitEnv()
 	routes.InitRouter()
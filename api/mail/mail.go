@@ -0,0 +1,99 @@
+// Package mail sends account emails through SMTP, or writes them to the log or local files in dev.
+package mail
+
+import (
+	"bytes"
+	"fmt"
+	"groupbuying.online/api/structs"
+	"io/ioutil"
+	"log"
+	"mime"
+	"net"
+	"net/smtp"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Message struct {
+	To      string
+	Subject string
+	Body    string // plain text
+}
+
+type Mailer interface {
+	Send(msg Message) error
+}
+
+// SMTP mailer when smtpHost is configured, else a LogMailer
+func New(conf structs.MailConfig) Mailer {
+	if conf.SMTPHost == "" {
+		return &LogMailer{From: conf.From, Dir: conf.LogDir}
+	}
+	port := conf.SMTPPort
+	if port == 0 {
+		port = 587
+	}
+	return &SMTPMailer{
+		Addr:     net.JoinHostPort(conf.SMTPHost, strconv.Itoa(port)),
+		Host:     conf.SMTPHost,
+		Username: conf.SMTPUsername,
+		Password: conf.SMTPPassword,
+		From:     conf.From,
+	}
+}
+
+// Header values can't contain line breaks, or a recipient could add headers
+func headerValue(s string) string {
+	return strings.NewReplacer("\r", "", "\n", "").Replace(s)
+}
+
+func format(from string, msg Message) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", headerValue(from))
+	fmt.Fprintf(&b, "To: %s\r\n", headerValue(msg.To))
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", headerValue(msg.Subject)))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().UTC().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	b.WriteString(strings.Replace(msg.Body, "\n", "\r\n", -1))
+	return b.Bytes()
+}
+
+// Sends with STARTTLS and PLAIN auth when a username is set
+type SMTPMailer struct {
+	Addr     string
+	Host     string
+	Username string
+	Password string
+	From     string
+}
+
+func (m *SMTPMailer) Send(msg Message) error {
+	var auth smtp.Auth
+	if m.Username != "" {
+		auth = smtp.PlainAuth("", m.Username, m.Password, m.Host)
+	}
+	return smtp.SendMail(m.Addr, auth, m.From, []string{headerValue(msg.To)}, format(m.From, msg))
+}
+
+// Writes mails to Dir as .eml files, or to the log when Dir is empty
+type LogMailer struct {
+	From string
+	Dir  string
+}
+
+func (m *LogMailer) Send(msg Message) error {
+	if m.Dir == "" {
+		log.Printf("mail to %s: %s\n%s", msg.To, msg.Subject, msg.Body)
+		return nil
+	}
+	name := fmt.Sprintf("%d-%s.eml", time.Now().UnixNano(), strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || r == '.' {
+			return '_'
+		}
+		return r
+	}, msg.To))
+	return ioutil.WriteFile(filepath.Join(m.Dir, name), format(m.From, msg), 0600)
+}
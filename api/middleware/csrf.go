@@ -0,0 +1,68 @@
+package middleware
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"github.com/gorilla/sessions"
+	"groupbuying.online/api/auth"
+	"groupbuying.online/api/structs"
+	"net/http"
+)
+
+const (
+	csrfSessionKey = "csrfSecret"
+	CSRFHeader     = "X-CSRF-Token"
+)
+
+// Token for the session's csrf secret, signed with conf.CSRFKey so that changing the key revokes all tokens
+func csrfToken(conf *structs.Config, secret string) string {
+	mac := hmac.New(sha256.New, []byte(conf.CSRFKey))
+	mac.Write([]byte(secret))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Returns the csrf token of the session, creating its secret and saving the session if it has none
+func IssueCSRFToken(store *sessions.CookieStore, conf *structs.Config, w http.ResponseWriter, r *http.Request) (string, error) {
+	session, _ := store.Get(r, conf.SessionName)
+	secret, ok := session.Values[csrfSessionKey].(string)
+	if !ok || secret == "" {
+		b := make([]byte, 32)
+		if _, err := rand.Read(b); err != nil {
+			return "", err
+		}
+		secret = base64.RawURLEncoding.EncodeToString(b)
+		session.Values[csrfSessionKey] = secret
+		if err := session.Save(r, w); err != nil {
+			return "", err
+		}
+	}
+	return csrfToken(conf, secret), nil
+}
+
+// Reject state changing requests without the session's token in the X-CSRF-Token header.
+// Requests with a bearer token are exempt, browsers don't attach it to cross site requests.
+func GetCSRFMiddleware(store *sessions.CookieStore, conf *structs.Config) Middleware {
+	return func(h http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			switch r.Method {
+			case http.MethodGet, http.MethodHead, http.MethodOptions:
+				h(w, r)
+				return
+			}
+			if auth.HasBearerToken(r) {
+				h(w, r)
+				return
+			}
+			session, _ := store.Get(r, conf.SessionName)
+			secret, _ := session.Values[csrfSessionKey].(string)
+			token := r.Header.Get(CSRFHeader)
+			if secret == "" || token == "" || !hmac.Equal([]byte(token), []byte(csrfToken(conf, secret))) {
+				http.Error(w, "Invalid CSRF token", http.StatusForbidden)
+				return
+			}
+			h(w, r)
+		}
+	}
+}
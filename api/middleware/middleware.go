@@ -1,8 +1,13 @@
 package middleware
 
 import (
+	"crypto/subtle"
+	"database/sql"
 	"github.com/gorilla/sessions"
+	"google.golang.org/appengine"
+	"groupbuying.online/api/auth"
 	"groupbuying.online/api/structs"
+	"groupbuying.online/api/utils"
 	"net/http"
 )
 
@@ -17,15 +22,141 @@ func Use(h http.HandlerFunc, middleware ...Middleware) http.HandlerFunc {
 	return h
 }
 
-func GetAuthMiddleware(store *sessions.CookieStore, conf *structs.Config) Middleware {
+// Only allow requests with a valid bearer token or an active server side session,
+// and put the user id in the request context. Sessions and refresh tokens of banned users are revoked.
+func GetAuthMiddleware(store *sessions.CookieStore, conf *structs.Config, db *sql.DB, sessionStore auth.SessionStore) Middleware {
 	return func(h http.HandlerFunc) http.HandlerFunc {
 		return func(w http.ResponseWriter, r *http.Request) {
-			session, _ := store.Get(r, conf.SessionName)
-			if auth, ok := session.Values["authenticated"].(bool); !ok || !auth {
+			userId, isBearer, err := auth.UserIdFromRequest(r, conf.JWTKey)
+			if isBearer && err != nil {
+				w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+			if !isBearer {
+				session, err := getCookieSession(store, conf, sessionStore, r)
+				if err == auth.ErrSessionNotFound {
+					http.Error(w, "Forbidden", http.StatusForbidden)
+					return
+				}
+				if err == nil {
+					err = sessionStore.Touch(session, utils.GetClientIP(r, conf.TrustedProxies))
+				}
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+					return
+				}
+				userId = session.UserID
+			}
+			banned, err := isSanctioned(db, userId, "ban")
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if banned {
+				_, _ = sessionStore.RevokeAll(userId)
+				_ = auth.RevokeUserRefreshTokens(db, userId)
+				http.Error(w, "Banned", http.StatusForbidden)
+				return
+			}
+			h(w, r.WithContext(auth.WithUserId(r.Context(), userId)))
+		}
+	}
+}
+
+// Active server side session of the request's cookie, auth.ErrSessionNotFound if it has none
+func getCookieSession(store *sessions.CookieStore, conf *structs.Config, sessionStore auth.SessionStore,
+	r *http.Request) (structs.UserSession, error) {
+	cookieSession, _ := store.Get(r, conf.SessionName)
+	token, _ := cookieSession.Values[auth.CookieSessionKey].(string)
+	if token == "" {
+		return structs.UserSession{}, auth.ErrSessionNotFound
+	}
+	return sessionStore.Get(token)
+}
+
+// User id from the request context, bearer token or cookie session, empty if there is no valid one
+func getRequestUserId(store *sessions.CookieStore, conf *structs.Config, sessionStore auth.SessionStore,
+	r *http.Request) string {
+	if userId, found, err := auth.UserIdFromRequest(r, conf.JWTKey); found {
+		if err != nil {
+			return ""
+		}
+		return userId
+	}
+	session, err := getCookieSession(store, conf, sessionStore, r)
+	if err != nil {
+		return ""
+	}
+	return session.UserID
+}
+
+// Reject creating or editing content by suspended users, use after GetAuthMiddleware
+func GetPostingMiddleware(db *sql.DB) Middleware {
+	return func(h http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodPost || r.Method == http.MethodPut {
+				userId, _ := auth.UserIdFromContext(r.Context())
+				suspended, err := isSanctioned(db, userId, "suspension")
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+					return
+				}
+				if suspended {
+					http.Error(w, "Suspended", http.StatusForbidden)
+					return
+				}
+			}
+			h(w, r)
+		}
+	}
+}
+
+// Whether the user has an unexpired sanction of kind, see users_banned in sql/common/0_users.sql
+func isSanctioned(db *sql.DB, userId string, kind string) (sanctioned bool, err error) {
+	err = db.QueryRow(`SELECT is_user_sanctioned($1, $2)`, userId, kind).Scan(&sanctioned)
+	return sanctioned, err
+}
+
+// Ranks of user roles, a role has the permissions of all roles ranked below it
+var roleRanks = map[string]int{
+	"user":      0,
+	"moderator": 1,
+	"admin":     2,
+}
+
+// Only allow users with minRole or above, use after GetAuthMiddleware.
+// The role is read from the db on each request so that role changes apply to existing sessions.
+func GetRoleMiddleware(db *sql.DB, minRole string) Middleware {
+	return func(h http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			userId, _ := auth.UserIdFromContext(r.Context())
+			var role string
+			if err := db.QueryRow(`SELECT role FROM users WHERE id=$1`, userId).Scan(&role); err != nil ||
+				roleRanks[role] < roleRanks[minRole] {
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+			h(w, r)
+		}
+	}
+}
+
+// Only allow scheduled jobs: App Engine cron sets X-Appengine-Cron and strips it from
+// external requests, elsewhere the caller has to present the configured cron key.
+func GetCronMiddleware(conf *structs.Config) Middleware {
+	return func(h http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			isCron := appengine.IsAppEngine() && r.Header.Get("X-Appengine-Cron") == "true"
+			if !isCron && conf.CronKey != "" {
+				key := []byte(r.Header.Get("X-Cron-Key"))
+				isCron = subtle.ConstantTimeCompare(key, []byte(conf.CronKey)) == 1
+			}
+			if !isCron {
 				http.Error(w, "Forbidden", http.StatusForbidden)
 				return
 			}
 			h(w, r)
 		}
 	}
-}
\ No newline at end of file
+}
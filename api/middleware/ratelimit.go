@@ -0,0 +1,117 @@
+package middleware
+
+import (
+	"fmt"
+	"github.com/gorilla/sessions"
+	"groupbuying.online/api/auth"
+	"groupbuying.online/api/structs"
+	"groupbuying.online/api/utils"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Token buckets of rate limited clients by key, implementations must be safe for concurrent use
+type RateLimitStore interface {
+	// Take a token from the bucket of key, refilling it for the time since the last take.
+	// Returns whether a token was taken, the tokens left, the time until the next token
+	// and the time until the bucket is full again.
+	Take(key string, limit structs.RateLimit, now time.Time) (allowed bool, remaining int, nextToken time.Duration,
+		untilFull time.Duration)
+}
+
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+	full    time.Time // when the bucket will be full again
+}
+
+// In-memory RateLimitStore, limits are per instance when the app runs on more than one
+type MemoryRateLimitStore struct {
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+// Full buckets are dropped at most this often to bound memory
+const rateLimitSweepInterval = time.Minute
+
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{buckets: make(map[string]*tokenBucket)}
+}
+
+func (s *MemoryRateLimitStore) Take(key string, limit structs.RateLimit, now time.Time) (bool, int, time.Duration,
+	time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if now.Sub(s.lastSweep) > rateLimitSweepInterval {
+		for k, b := range s.buckets {
+			if now.After(b.full) {
+				delete(s.buckets, k)
+			}
+		}
+		s.lastSweep = now
+	}
+
+	perSecond := limit.PerMinute / 60
+	burst := float64(limit.Burst)
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: burst, updated: now}
+		s.buckets[key] = b
+	}
+	b.tokens = math.Min(burst, b.tokens+now.Sub(b.updated).Seconds()*perSecond)
+	b.updated = now
+
+	allowed := b.tokens >= 1
+	if allowed {
+		b.tokens--
+	}
+	b.full = now.Add(secondsToDuration((burst - b.tokens) / perSecond))
+	nextToken := time.Duration(0)
+	if b.tokens < 1 {
+		nextToken = secondsToDuration((1 - b.tokens) / perSecond)
+	}
+	return allowed, int(b.tokens), nextToken, b.full.Sub(now)
+}
+
+func secondsToDuration(seconds float64) time.Duration {
+	return time.Duration(seconds * float64(time.Second))
+}
+
+func ceilSeconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
+
+// Limit requests to the budget of group in conf.RateLimits, no limit if the group has none.
+// Clients are keyed by session user id when logged in and by ip otherwise.
+// Sets the RateLimit-Limit, RateLimit-Remaining and RateLimit-Reset headers, the reset being the time until
+// the whole burst is available again, and Retry-After, the time until the next token, with status 429 when over the limit.
+func GetRateLimitMiddleware(store *sessions.CookieStore, conf *structs.Config, sessionStore auth.SessionStore,
+	limits RateLimitStore, group string) Middleware {
+	return func(h http.HandlerFunc) http.HandlerFunc {
+		limit, ok := conf.RateLimits[group]
+		if !ok || limit.Burst <= 0 || limit.PerMinute <= 0 {
+			return h
+		}
+		return func(w http.ResponseWriter, r *http.Request) {
+			key := group + ":ip:" + utils.GetClientIP(r, conf.TrustedProxies)
+			if userId := getRequestUserId(store, conf, sessionStore, r); userId != "" {
+				key = group + ":user:" + userId
+			}
+			allowed, remaining, nextToken, untilFull := limits.Take(key, limit, time.Now())
+			w.Header().Set("RateLimit-Limit", strconv.Itoa(limit.Burst))
+			w.Header().Set("RateLimit-Remaining", strconv.Itoa(remaining))
+			w.Header().Set("RateLimit-Reset", ceilSeconds(untilFull))
+			if !allowed {
+				retryAfter := ceilSeconds(nextToken)
+				w.Header().Set("Retry-After", retryAfter)
+				http.Error(w, fmt.Sprintf("Too many requests, retry in %ss", retryAfter), http.StatusTooManyRequests)
+				return
+			}
+			h(w, r)
+		}
+	}
+}
@@ -0,0 +1,429 @@
+package routes
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/iancoleman/strcase"
+	"groupbuying.online/api/env"
+	"groupbuying.online/api/structs"
+	"groupbuying.online/api/utils"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Roles that admins can assign, see roleRanks in middleware/middleware.go
+var userRoles = map[string]bool{
+	"user":      true,
+	"moderator": true,
+	"admin":     true,
+}
+
+// Editable columns of deal_categories and suggestions by json key, with the json type of their values
+var dealCategoryCols = map[string]string{
+	"name":        "string",
+	"displayName": "string",
+	"iconUrl":     "string",
+	"priority":    "number",
+	"isActive":    "bool",
+}
+
+var suggestionCols = map[string]string{
+	"searchString": "string",
+	"posterId":     "string",
+	"categoryId":   "number",
+	"latitude":     "number",
+	"longitude":    "number",
+	"radiusKm":     "number",
+	"bannerUrl":    "string",
+	"activeFrom":   "time",
+	"inactiveBy":   "time",
+}
+
+func writeAdminActionResult(w http.ResponseWriter, targetType string, targetId string, err error) {
+	if err == sql.ErrNoRows {
+		utils.WriteErrorJsonResponse(w, targetType+" not found")
+		return
+	}
+	if err != nil {
+		utils.WriteErrorJsonResponse(w, err.Error())
+		return
+	}
+	utils.WriteSuccessJsonResponse(w, targetId)
+}
+
+// Reads the columns in cols from a request body to column values,
+// json null sets the column to NULL and times are RFC 3339 strings.
+func readColValues(result map[string]interface{}, cols map[string]string) (map[string]interface{}, error) {
+	colValues := make(map[string]interface{})
+	for key, value := range result {
+		colType, ok := cols[key]
+		if !ok {
+			return nil, fmt.Errorf("invalid key '%s'", key)
+		}
+		snakeKey := strcase.ToSnake(key)
+		if value == nil {
+			colValues[snakeKey] = nil
+			continue
+		}
+		switch colType {
+		case "string":
+			_, ok = value.(string)
+		case "number":
+			_, ok = value.(float64)
+		case "bool":
+			_, ok = value.(bool)
+		case "time":
+			var timeStr string
+			if timeStr, ok = value.(string); ok {
+				var err error
+				if value, err = time.Parse(time.RFC3339, timeStr); err != nil {
+					ok = false
+				}
+			}
+		}
+		if !ok {
+			return nil, fmt.Errorf("invalid value for '%s'", key)
+		}
+		colValues[snakeKey] = value
+	}
+	if len(colValues) == 0 {
+		return nil, fmt.Errorf("no values")
+	}
+	return colValues, nil
+}
+
+// Column names, placeholders from $startIdx and values of colValues in the same order
+func getColPlaceholders(colValues map[string]interface{}, startIdx int) (cols []string, placeholders []string, values []interface{}) {
+	for col, val := range colValues {
+		cols = append(cols, col)
+		placeholders = append(placeholders, fmt.Sprintf("$%d", startIdx+len(values)))
+		values = append(values, val)
+	}
+	return cols, placeholders, values
+}
+
+func insertColValues(tx *sql.Tx, table string, colValues map[string]interface{}) (id string, err error) {
+	cols, placeholders, values := getColPlaceholders(colValues, 1)
+	err = tx.QueryRow(fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s) RETURNING id",
+		table, strings.Join(cols, ","), strings.Join(placeholders, ",")), values...).Scan(&id)
+	return id, err
+}
+
+func updateColValues(tx *sql.Tx, table string, id string, colValues map[string]interface{}) (string, error) {
+	cols, placeholders, values := getColPlaceholders(colValues, 2)
+	updateStrings := make([]string, len(cols))
+	for i := range cols {
+		updateStrings[i] = cols[i] + "=" + placeholders[i]
+	}
+	err := tx.QueryRow(fmt.Sprintf("UPDATE %s SET %s WHERE id=$1 RETURNING id",
+		table, strings.Join(updateStrings, ",")), append([]interface{}{id}, values...)...).Scan(&id)
+	return id, err
+}
+
+// Bans
+
+// Kinds of sanctions in users_banned
+var banKinds = map[string]bool{
+	"ban":        true,
+	"suspension": true,
+	"shadow":     true,
+}
+
+// Unexpired sanctions, latest first, filter with `kind`
+func getBannedUsers(w http.ResponseWriter, r *http.Request) {
+	var kind interface{}
+	if kindStr := r.URL.Query().Get("kind"); kindStr != "" {
+		if !banKinds[kindStr] {
+			utils.WriteErrorJsonResponse(w, "invalid kind")
+			return
+		}
+		kind = kindStr
+	}
+	rows, err := env.Db.Query(`SELECT u.id, u.display_name, u.image_url, u.email, COALESCE(u.fir_id, ''),
+		u_b.kind, u_b.reason, u_b.expires_at, u_b.banned_by, u_b.created_at
+		FROM users_banned u_b INNER JOIN users u ON u.id = u_b.user_id
+		WHERE is_user_sanctioned(u_b.user_id, u_b.kind) AND ($1::text ISNULL OR u_b.kind = $1)
+		ORDER BY u_b.created_at DESC`, kind)
+	if err != nil {
+		utils.WriteErrorJsonResponse(w, err.Error())
+		return
+	}
+	defer utils.CloseRows(rows)
+	bans := []structs.BannedUser{}
+	for rows.Next() {
+		var ban structs.BannedUser
+		err = rows.Scan(&ban.User.ID, &ban.User.DisplayName, &ban.User.ImageURL, &ban.User.Email,
+			&ban.User.FIRID, &ban.Kind, &ban.Reason, &ban.ExpiresAt, &ban.BannedBy, &ban.BannedAt)
+		if err != nil {
+			utils.WriteErrorJsonResponse(w, err.Error())
+			return
+		}
+		bans = append(bans, ban)
+	}
+	utils.WriteStructs(w, bans)
+}
+
+// Sanctions a user until expiresAt, or permanently if nil, replacing a previous sanction of the same kind.
+// Moderators and admins can not be banned.
+func insertUserBan(tx *sql.Tx, userId string, kind string, reason string, expiresAt *time.Time,
+	bannedBy string) (id string, err error) {
+	err = tx.QueryRow(`INSERT INTO users_banned (user_id, kind, reason, expires_at, banned_by)
+		SELECT id, $2, NULLIF($3, ''), $4, $5 FROM users WHERE id = $1 AND role = 'user'
+		ON CONFLICT (user_id, kind) DO UPDATE
+		SET reason = EXCLUDED.reason, expires_at = EXCLUDED.expires_at, banned_by = EXCLUDED.banned_by,
+			created_at = timezone('utc', now())
+		RETURNING user_id`, userId, kind, reason, expiresAt, bannedBy).Scan(&id)
+	return id, err
+}
+
+// Ban a user, body: {"userId": "...", "kind": "ban" | "suspension" | "shadow", "reason": "...", "durationHours": 24}.
+// kind defaults to ban, without durationHours the sanction is permanent.
+func banUser(w http.ResponseWriter, r *http.Request) {
+	result, err := utils.ReadRequestToJson(r)
+	if err != nil {
+		utils.WriteErrorJsonResponse(w, err.Error())
+		return
+	}
+	userId, ok := result["userId"].(string)
+	kind, hasKind := result["kind"].(string)
+	if !hasKind {
+		kind = "ban"
+	}
+	reason, _ := result["reason"].(string)
+	if !ok || !utils.IsValidUUID(userId) || !banKinds[kind] || len(reason) > maxReportReasonLength {
+		utils.WriteErrorJsonResponse(w, "invalid input")
+		return
+	}
+	var expiresAt *time.Time
+	if hours, ok := result["durationHours"].(float64); ok {
+		if hours <= 0 {
+			utils.WriteErrorJsonResponse(w, "invalid durationHours")
+			return
+		}
+		expiry := time.Now().UTC().Add(time.Duration(hours * float64(time.Hour)))
+		expiresAt = &expiry
+	}
+	actorId, _ := utils.GetUserIdInSession(r)
+	bannedId, err := runAuditedAction(r, "user."+kind, "user", userId, result, func(tx *sql.Tx) (string, error) {
+		return insertUserBan(tx, userId, kind, reason, expiresAt, actorId)
+	})
+	if err == nil && kind == "ban" {
+		// revoke sessions and refresh tokens now rather than on the next request of each device
+		if _, err := logoutEverywhere(bannedId); err != nil {
+			log.Printf("error logging out banned user '%s': %s", bannedId, err)
+		}
+	}
+	writeAdminActionResult(w, "bannable user", bannedId, err)
+}
+
+// Lift the sanction of `kind` on a user, or all of them if not given
+func unbanUser(w http.ResponseWriter, r *http.Request) {
+	userId, err := getURLParamUUID("userId", r)
+	if err != nil {
+		utils.WriteErrorJsonResponse(w, err.Error())
+		return
+	}
+	var kind interface{}
+	if kindStr := r.URL.Query().Get("kind"); kindStr != "" {
+		if !banKinds[kindStr] {
+			utils.WriteErrorJsonResponse(w, "invalid kind")
+			return
+		}
+		kind = kindStr
+	}
+	details := map[string]interface{}{"kind": kind}
+	unbannedId, err := runAuditedAction(r, "user.unban", "user", userId, details, func(tx *sql.Tx) (id string, err error) {
+		err = tx.QueryRow(`WITH lifted AS (
+				DELETE FROM users_banned WHERE user_id = $1 AND ($2::text ISNULL OR kind = $2) RETURNING user_id
+			) SELECT DISTINCT user_id FROM lifted`, userId, kind).Scan(&id)
+		return id, err
+	})
+	writeAdminActionResult(w, "ban", unbannedId, err)
+}
+
+// Change a user's role, body: {"role": "moderator"}, admins can not change their own role
+func setUserRole(w http.ResponseWriter, r *http.Request) {
+	userId, err := getURLParamUUID("userId", r)
+	if err != nil {
+		utils.WriteErrorJsonResponse(w, err.Error())
+		return
+	}
+	result, err := utils.ReadRequestToJson(r)
+	if err != nil {
+		utils.WriteErrorJsonResponse(w, err.Error())
+		return
+	}
+	role, _ := result["role"].(string)
+	actorId, _ := utils.GetUserIdInSession(r)
+	if !userRoles[role] || userId == actorId {
+		utils.WriteErrorJsonResponse(w, "invalid input")
+		return
+	}
+	details := map[string]interface{}{"role": role}
+	updatedId, err := runAuditedAction(r, "user.role", "user", userId, details, func(tx *sql.Tx) (id string, err error) {
+		err = tx.QueryRow(`UPDATE users SET role = $2 WHERE id = $1 RETURNING id`, userId, role).Scan(&id)
+		return id, err
+	})
+	writeAdminActionResult(w, "user", updatedId, err)
+}
+
+// Categories
+
+// Add a deal category, body has the keys in dealCategoryCols
+func createDealCategory(w http.ResponseWriter, r *http.Request) {
+	result, err := utils.ReadRequestToJson(r)
+	if err != nil {
+		utils.WriteErrorJsonResponse(w, err.Error())
+		return
+	}
+	colValues, err := readColValues(result, dealCategoryCols)
+	if err != nil {
+		utils.WriteErrorJsonResponse(w, err.Error())
+		return
+	}
+	if colValues["name"] == nil || colValues["display_name"] == nil {
+		utils.WriteErrorJsonResponse(w, "missing name or displayName")
+		return
+	}
+	categoryId, err := runAuditedAction(r, "category.create", "category", "", result, func(tx *sql.Tx) (string, error) {
+		return insertColValues(tx, "deal_categories", colValues)
+	})
+	writeAdminActionResult(w, "category", categoryId, err)
+}
+
+// Update a deal category, body has any of the keys in dealCategoryCols
+func updateDealCategory(w http.ResponseWriter, r *http.Request) {
+	categoryIdStr, err := getURLParam("categoryId", r)
+	categoryId, convErr := strconv.Atoi(categoryIdStr)
+	if err != nil || convErr != nil {
+		utils.WriteErrorJsonResponse(w, "invalid category id")
+		return
+	}
+	result, err := utils.ReadRequestToJson(r)
+	if err != nil {
+		utils.WriteErrorJsonResponse(w, err.Error())
+		return
+	}
+	colValues, err := readColValues(result, dealCategoryCols)
+	if err != nil {
+		utils.WriteErrorJsonResponse(w, err.Error())
+		return
+	}
+	updatedId, err := runAuditedAction(r, "category.update", "category", strconv.Itoa(categoryId), result, func(tx *sql.Tx) (string, error) {
+		return updateColValues(tx, "deal_categories", strconv.Itoa(categoryId), colValues)
+	})
+	writeAdminActionResult(w, "category", updatedId, err)
+}
+
+// Suggestions
+
+// All suggestions including inactive ones, latest first
+func getAllSuggestions(w http.ResponseWriter, r *http.Request) {
+	rows, err := env.Db.Query(`SELECT id, search_string, poster_id, category_id, latitude, longitude,
+		radius_km, banner_url, active_from, inactive_by FROM suggestions ORDER BY active_from DESC NULLS LAST`)
+	if err != nil {
+		utils.WriteErrorJsonResponse(w, err.Error())
+		return
+	}
+	defer utils.CloseRows(rows)
+	suggestions := []structs.Suggestion{}
+	for rows.Next() {
+		var s structs.Suggestion
+		err = rows.Scan(&s.ID, &s.SearchString, &s.PosterID, &s.CategoryID, &s.Latitude, &s.Longitude,
+			&s.RadiusKm, &s.BannerUrl, &s.ActiveFrom, &s.InactiveBy)
+		if err != nil {
+			utils.WriteErrorJsonResponse(w, err.Error())
+			return
+		}
+		suggestions = append(suggestions, s)
+	}
+	utils.WriteStructs(w, suggestions)
+}
+
+// Add a suggestion, body has the keys in suggestionCols
+func createSuggestion(w http.ResponseWriter, r *http.Request) {
+	result, err := utils.ReadRequestToJson(r)
+	if err != nil {
+		utils.WriteErrorJsonResponse(w, err.Error())
+		return
+	}
+	colValues, err := readColValues(result, suggestionCols)
+	if err != nil {
+		utils.WriteErrorJsonResponse(w, err.Error())
+		return
+	}
+	suggestionId, err := runAuditedAction(r, "suggestion.create", "suggestion", "", result, func(tx *sql.Tx) (string, error) {
+		return insertColValues(tx, "suggestions", colValues)
+	})
+	writeAdminActionResult(w, "suggestion", suggestionId, err)
+}
+
+// Update a suggestion, body has any of the keys in suggestionCols
+func updateSuggestion(w http.ResponseWriter, r *http.Request) {
+	suggestionId, err := getURLParamUUID("suggestionId", r)
+	if err != nil {
+		utils.WriteErrorJsonResponse(w, err.Error())
+		return
+	}
+	result, err := utils.ReadRequestToJson(r)
+	if err != nil {
+		utils.WriteErrorJsonResponse(w, err.Error())
+		return
+	}
+	colValues, err := readColValues(result, suggestionCols)
+	if err != nil {
+		utils.WriteErrorJsonResponse(w, err.Error())
+		return
+	}
+	updatedId, err := runAuditedAction(r, "suggestion.update", "suggestion", suggestionId, result, func(tx *sql.Tx) (string, error) {
+		return updateColValues(tx, "suggestions", suggestionId, colValues)
+	})
+	writeAdminActionResult(w, "suggestion", updatedId, err)
+}
+
+func deleteSuggestion(w http.ResponseWriter, r *http.Request) {
+	suggestionId, err := getURLParamUUID("suggestionId", r)
+	if err != nil {
+		utils.WriteErrorJsonResponse(w, err.Error())
+		return
+	}
+	deletedId, err := runAuditedAction(r, "suggestion.delete", "suggestion", suggestionId, nil, func(tx *sql.Tx) (id string, err error) {
+		err = tx.QueryRow(`DELETE FROM suggestions WHERE id = $1 RETURNING id`, suggestionId).Scan(&id)
+		return id, err
+	})
+	writeAdminActionResult(w, "suggestion", deletedId, err)
+}
+
+// Featured deals
+
+// Feature or unfeature a deal, body: {"isFeatured": true, "featuredUrl": "https://..."}
+func setDealFeatured(w http.ResponseWriter, r *http.Request) {
+	dealId, err := getURLParamUUID("dealId", r)
+	if err != nil {
+		utils.WriteErrorJsonResponse(w, err.Error())
+		return
+	}
+	result, err := utils.ReadRequestToJson(r)
+	if err != nil {
+		utils.WriteErrorJsonResponse(w, err.Error())
+		return
+	}
+	isFeatured, ok := result["isFeatured"].(bool)
+	var featuredUrl interface{}
+	if urlStr, hasUrl := result["featuredUrl"].(string); hasUrl && isFeatured {
+		featuredUrl = urlStr
+	}
+	if !ok {
+		utils.WriteErrorJsonResponse(w, "invalid input")
+		return
+	}
+	featuredId, err := runAuditedAction(r, "deal.feature", "deal", dealId, result, func(tx *sql.Tx) (id string, err error) {
+		err = tx.QueryRow(`UPDATE deals SET is_featured = $2, featured_url = $3 WHERE id = $1 RETURNING id`,
+			dealId, isFeatured, featuredUrl).Scan(&id)
+		return id, err
+	})
+	writeAdminActionResult(w, "deal", featuredId, err)
+}
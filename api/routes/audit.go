@@ -0,0 +1,232 @@
+package routes
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"github.com/lib/pq"
+	"groupbuying.online/api/env"
+	"groupbuying.online/api/structs"
+	"groupbuying.online/api/utils"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// *sql.DB or *sql.Tx, so that audit entries can be written in the same transaction as the action
+type sqlRunner interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// Tables of target types whose rows are snapshotted before and after an action
+var auditTargetTables = map[string]string{
+	"deal":       "deals",
+	"comment":    "deal_comments",
+	"user":       "users",
+	"category":   "deal_categories",
+	"suggestion": "suggestions",
+}
+
+type auditEntry struct {
+	actorId    string
+	action     string
+	targetType string
+	targetId   string
+	before     json.RawMessage
+	after      json.RawMessage
+	details    map[string]interface{}
+}
+
+// Credentials that are left out of snapshots
+var auditRedactedCols = []string{"password_hash", "totp_secret"}
+
+// Row of an audited target as json, nil if the target type has no table or the row does not exist
+func getAuditSnapshot(db sqlRunner, targetType string, targetId string) (json.RawMessage, error) {
+	table, ok := auditTargetTables[targetType]
+	if !ok || targetId == "" {
+		return nil, nil
+	}
+	var snapshot []byte
+	err := db.QueryRow(`SELECT to_jsonb(t) - $2::text[] FROM `+table+` t WHERE t.id::text = $1`,
+		targetId, pq.Array(auditRedactedCols)).Scan(&snapshot)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return snapshot, err
+}
+
+// Appends an entry to audit_log with the address and user agent of the request
+func writeAuditLog(db sqlRunner, r *http.Request, entry auditEntry) error {
+	var details interface{}
+	if entry.details != nil {
+		detailsJson, err := json.Marshal(entry.details)
+		if err != nil {
+			return err
+		}
+		details = string(detailsJson)
+	}
+	_, err := db.Exec(`INSERT INTO audit_log
+		(actor_id, action, target_type, target_id, before, after, details, ip, user_agent)
+		VALUES (NULLIF($1, '')::uuid, $2, NULLIF($3, ''), NULLIF($4, ''), $5, $6, $7, $8, $9)`,
+		entry.actorId, entry.action, entry.targetType, entry.targetId,
+		rawJsonParam(entry.before), rawJsonParam(entry.after), details,
+		utils.GetClientIP(r, env.Conf.TrustedProxies), r.UserAgent())
+	return err
+}
+
+func rawJsonParam(raw json.RawMessage) interface{} {
+	if raw == nil {
+		return nil
+	}
+	return string(raw)
+}
+
+// Runs an action of the session user, e.g. an admin action or a change to their own profile,
+// and writes its audit log entry with snapshots of the target before and after in one transaction.
+// act returns the id of the target or sql.ErrNoRows if there is none, targetId is empty for creates.
+func runAuditedAction(r *http.Request, action string, targetType string, targetId string,
+	details map[string]interface{}, act func(tx *sql.Tx) (string, error)) (string, error) {
+	actorId, _ := utils.GetUserIdInSession(r)
+	tx, err := env.Db.Begin()
+	if err != nil {
+		return "", err
+	}
+	entry := auditEntry{actorId: actorId, action: action, targetType: targetType, details: details}
+	entry.before, err = getAuditSnapshot(tx, targetType, targetId)
+	if err == nil {
+		entry.targetId, err = act(tx)
+	}
+	if err == nil {
+		entry.after, err = getAuditSnapshot(tx, targetType, entry.targetId)
+	}
+	if err == nil {
+		err = writeAuditLog(tx, r, entry)
+	}
+	if err != nil {
+		_ = tx.Rollback()
+		return "", err
+	}
+	return entry.targetId, tx.Commit()
+}
+
+// Writes an audit entry outside of a transaction, failures are logged and don't fail the request
+func recordAudit(r *http.Request, entry auditEntry) {
+	if err := writeAuditLog(env.Db, r, entry); err != nil {
+		log.Printf("error writing audit log for '%s' on %s '%s': %s",
+			entry.action, entry.targetType, entry.targetId, err)
+	}
+}
+
+// Audit log for admins, latest first.
+// Filter with `actorId`, `action`, `targetType`, `targetId` and RFC 3339 `since` / `until`,
+// page with `pageSize` and `offset`.
+func getAuditLog(w http.ResponseWriter, r *http.Request) {
+	values := r.URL.Query()
+	var filterStrings []string
+	var queryParams []interface{}
+	addFilter := func(filter string, param interface{}) {
+		queryParams = append(queryParams, param)
+		filterStrings = append(filterStrings, fmt.Sprintf(filter, len(queryParams)))
+	}
+	if actorId := values.Get("actorId"); actorId != "" {
+		if !utils.IsValidUUID(actorId) {
+			utils.WriteErrorJsonResponse(w, "invalid actorId")
+			return
+		}
+		addFilter("actor_id = $%d", actorId)
+	}
+	if action := values.Get("action"); action != "" {
+		addFilter("action = $%d", action)
+	}
+	if targetType := values.Get("targetType"); targetType != "" {
+		addFilter("target_type = $%d", targetType)
+	}
+	if targetId := values.Get("targetId"); targetId != "" {
+		addFilter("target_id = $%d", targetId)
+	}
+	for _, bound := range []struct{ param, filter string }{
+		{"since", "created_at >= $%d"},
+		{"until", "created_at < $%d"},
+	} {
+		if timeStr := values.Get(bound.param); timeStr != "" {
+			t, err := time.Parse(time.RFC3339, timeStr)
+			if err != nil {
+				utils.WriteErrorJsonResponse(w, fmt.Sprintf("invalid %s", bound.param))
+				return
+			}
+			addFilter(bound.filter, t.UTC())
+		}
+	}
+	pageSize := 50
+	if pageSizeNum, err := strconv.Atoi(values.Get("pageSize")); err == nil && pageSizeNum > 0 {
+		pageSize = pageSizeNum
+	}
+	offset := 0
+	if offsetNum, err := strconv.Atoi(values.Get("offset")); err == nil && offsetNum > 0 {
+		offset = offsetNum
+	}
+
+	filterStr := ""
+	if len(filterStrings) > 0 {
+		filterStr = " WHERE " + strings.Join(filterStrings, " AND ")
+	}
+	queryParams = append(queryParams, pageSize, offset)
+	rows, err := env.Db.Query(fmt.Sprintf(`SELECT id, actor_id, action, target_type, target_id,
+		before, after, details, ip, user_agent, created_at FROM audit_log%s
+		ORDER BY created_at DESC LIMIT $%d OFFSET $%d`, filterStr, len(queryParams)-1, len(queryParams)),
+		queryParams...)
+	if err != nil {
+		utils.WriteErrorJsonResponse(w, err.Error())
+		return
+	}
+	defer utils.CloseRows(rows)
+	entries := []structs.AuditLogEntry{}
+	for rows.Next() {
+		var entry structs.AuditLogEntry
+		var before, after, details []byte
+		err = rows.Scan(&entry.ID, &entry.ActorID, &entry.Action, &entry.TargetType, &entry.TargetID,
+			&before, &after, &details, &entry.IP, &entry.UserAgent, &entry.CreatedAt)
+		if err != nil {
+			utils.WriteErrorJsonResponse(w, err.Error())
+			return
+		}
+		entry.Before, entry.After, entry.Details = before, after, details
+		entries = append(entries, entry)
+	}
+	utils.WriteStructs(w, entries)
+}
+
+// Deletes audit log entries older than auditLogRetentionDays, called daily by cron
+func purgeAuditLog(w http.ResponseWriter, r *http.Request) {
+	if env.Conf.AuditLogRetentionDays <= 0 {
+		utils.WriteJsonResponse(w, "deleted", 0)
+		return
+	}
+	tx, err := env.Db.Begin()
+	if err != nil {
+		utils.WriteError(w, err.Error())
+		return
+	}
+	// allows the delete past the append-only trigger in sql/common/5_audit_log.sql
+	_, err = tx.Exec(`SET LOCAL audit.retention = 'on'`)
+	var res sql.Result
+	if err == nil {
+		res, err = tx.Exec(`DELETE FROM audit_log
+			WHERE created_at < timezone('utc', now()) - make_interval(days => $1)`,
+			env.Conf.AuditLogRetentionDays)
+	}
+	if err != nil {
+		_ = tx.Rollback()
+		utils.WriteError(w, err.Error())
+		return
+	}
+	if err = tx.Commit(); err != nil {
+		utils.WriteError(w, err.Error())
+		return
+	}
+	deleted, _ := res.RowsAffected()
+	utils.WriteJsonResponse(w, "deleted", deleted)
+}
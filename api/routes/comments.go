@@ -0,0 +1,569 @@
+package routes
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/lib/pq"
+	"groupbuying.online/api/env"
+	"groupbuying.online/api/screening"
+	"groupbuying.online/api/structs"
+	"groupbuying.online/api/utils"
+	"log"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const maxCommentLength = 240
+
+// Allowed reactions on comments, see deal_comment_reactions in sql/common/1_deals.sql
+var commentReactions = map[string]bool{
+	"like":  true,
+	"love":  true,
+	"laugh": true,
+	"wow":   true,
+	"sad":   true,
+	"angry": true,
+}
+
+// @username, usernames are alphanumeric display names, see utils.IsValidUsername
+var mentionRegexp = regexp.MustCompile(`@([\pL\pN]+)`)
+
+// Filter for comments `c` that the viewer sees: not removed, held ones only to their author,
+// hidden ones only to their author and the deal poster, and none of shadow banned or blocked users.
+// viewerExpr is as in getNotBlockedFilter.
+func getCommentVisibleFilter(viewerExpr string) string {
+	return `c.removed_at ISNULL AND ((c.moderated_at ISNULL AND c.held_at ISNULL) OR c.user_id = ` + viewerExpr + `)
+		AND (c.hidden_at ISNULL OR c.user_id = ` + viewerExpr + `
+			OR EXISTS (SELECT 1 FROM deals p WHERE p.id = c.deal_id AND p.poster_id = ` + viewerExpr + `))
+		AND ` + getNotShadowBannedFilter("c.user_id", viewerExpr) + `
+		AND ` + getNotBlockedFilter("c.user_id", viewerExpr)
+}
+
+// Whether the session user, or a logged out viewer, sees the comment, see getCommentVisibleFilter
+func isCommentVisible(r *http.Request, commentId string) (bool, error) {
+	var viewerId interface{}
+	if userId, ok := utils.GetUserIdInSession(r); ok {
+		viewerId = userId
+	}
+	var visible bool
+	err := env.Db.QueryRow(`SELECT EXISTS (SELECT 1 FROM deal_comments c
+		WHERE c.id = $1 AND `+getCommentVisibleFilter("$2::uuid")+`)`, commentId, viewerId).Scan(&visible)
+	return visible, err
+}
+
+// Comments of a deal ordered by posted_at, paged with `limit` and `base` (posted_at of last comment seen).
+// With `threaded=true`, pages over top level comments and nests their replies.
+// Pinned comments come first on the first page, hidden ones are only shown to their author and the deal poster.
+func getDealCommentsByDealId(w http.ResponseWriter, r *http.Request) {
+	dealId, err := getURLParamUUID("dealId", r)
+	if err != nil {
+		utils.WriteErrorJsonResponse(w, err.Error())
+		return
+	}
+	values := r.URL.Query()
+	limit := 50
+	if limitNum, err := strconv.Atoi(values.Get("limit")); err == nil && limitNum > 0 {
+		limit = limitNum
+	}
+	threaded, _ := strconv.ParseBool(values.Get("threaded"))
+	var viewerId interface{}
+	reqUserId, ok := utils.GetUserIdInSession(r)
+	if ok {
+		viewerId = reqUserId
+	} else {
+		reqUserId = ""
+	}
+
+	base := values.Get("base")
+	queryParams := []interface{}{dealId, limit, viewerId, base == ""}
+	baseFilter := ""
+	if base != "" {
+		iso8601Layout := "2006-01-02T15:04:05Z"
+		baseT, err := time.Parse(iso8601Layout, base)
+		if err != nil {
+			utils.WriteErrorJsonResponse(w, "Wrong time")
+			return
+		}
+		baseFilter = " AND c.posted_at > $5"
+		queryParams = append(queryParams, baseT)
+	}
+
+	visibleFilter := getCommentVisibleFilter("$3::uuid")
+	pageFilter := "c.deal_id = $1 AND " + visibleFilter
+	if threaded {
+		pageFilter += " AND c.parent_id ISNULL"
+	}
+	// pinned comments are not paged, they are all on the first page
+	pageQuery := `(SELECT c.* FROM deal_comments c
+			WHERE ` + pageFilter + ` AND c.pinned_at ISNULL` + baseFilter + `
+			ORDER BY c.posted_at LIMIT $2)
+		UNION ALL
+		(SELECT c.* FROM deal_comments c WHERE ` + pageFilter + ` AND c.pinned_at NOTNULL AND $4::bool)`
+	selectCols := `SELECT c.id, c.user_id, u.fir_id, u.display_name, c.comment_str, c.posted_at,
+		c.parent_id, c.edited_at, c.hidden_at NOTNULL, c.pinned_at NOTNULL, c.locked_at NOTNULL`
+	var query string
+	if threaded {
+		query = `WITH RECURSIVE page AS (` + pageQuery + `), thread AS (
+				SELECT * FROM page
+				UNION ALL
+				SELECT c.* FROM deal_comments c INNER JOIN thread t ON c.parent_id = t.id
+				WHERE ` + visibleFilter + `
+			) ` + selectCols + `
+			FROM thread c
+			INNER JOIN users u ON u.id = c.user_id
+			ORDER BY c.posted_at`
+	} else {
+		query = `WITH page AS (` + pageQuery + `) ` + selectCols + `
+			FROM page c
+			INNER JOIN users u ON u.id = c.user_id
+			ORDER BY c.posted_at`
+	}
+	rows, err := env.Db.Query(query, queryParams...)
+	if err != nil {
+		utils.WriteErrorJsonResponse(w, err.Error())
+		return
+	}
+	defer utils.CloseRows(rows)
+	dealComments := []structs.DealComment{}
+	for rows.Next() {
+		var dealComment structs.DealComment
+		err = rows.Scan(&dealComment.ID, &dealComment.UserID, &dealComment.UserFIRID,
+			&dealComment.Username, &dealComment.Comment, &dealComment.PostedAt,
+			&dealComment.ParentID, &dealComment.EditedAt,
+			&dealComment.IsHidden, &dealComment.IsPinned, &dealComment.IsLocked)
+		if err != nil {
+			utils.WriteErrorJsonResponse(w, err.Error())
+			return
+		}
+		dealComment.IsEdited = dealComment.EditedAt != nil
+		dealComments = append(dealComments, dealComment)
+	}
+	commentIds := make([]string, len(dealComments))
+	for i, dealComment := range dealComments {
+		commentIds[i] = dealComment.ID
+	}
+	reactions, err := getDealCommentReactions(commentIds, reqUserId)
+	if err != nil {
+		utils.WriteErrorJsonResponse(w, err.Error())
+		return
+	}
+	for i := range dealComments {
+		dealComments[i].Reactions = reactions[dealComments[i].ID]
+	}
+	if threaded {
+		dealComments = nestDealComments(dealComments)
+	}
+	sort.SliceStable(dealComments, func(i, j int) bool {
+		return dealComments[i].IsPinned && !dealComments[j].IsPinned
+	})
+	utils.WriteStructs(w, dealComments)
+}
+
+// Nests replies under their parents, comments must be ordered by posted_at
+func nestDealComments(dealComments []structs.DealComment) []structs.DealComment {
+	replies := make(map[string][]structs.DealComment)
+	var topLevel []structs.DealComment
+	for _, dealComment := range dealComments {
+		if dealComment.ParentID == nil {
+			topLevel = append(topLevel, dealComment)
+		} else {
+			replies[*dealComment.ParentID] = append(replies[*dealComment.ParentID], dealComment)
+		}
+	}
+	var withReplies func(comments []structs.DealComment) []structs.DealComment
+	withReplies = func(comments []structs.DealComment) []structs.DealComment {
+		for i := range comments {
+			comments[i].Replies = withReplies(replies[comments[i].ID])
+		}
+		return comments
+	}
+	if topLevel == nil {
+		return []structs.DealComment{}
+	}
+	return withReplies(topLevel)
+}
+
+func handleDealComment(w http.ResponseWriter, r *http.Request) {
+	result, err := utils.ReadRequestToJson(r)
+	utils.CheckFatalError(w, err)
+	dealId, ok1 := result["dealId"].(string)
+	userId, ok2 := result["userId"].(string)
+	comment, ok3 := result["comment"].(string)
+	reqUserId, ok4 := utils.GetUserIdInSession(r)
+
+	if !utils.IsValidUUID(dealId) || !utils.IsValidUUID(userId) ||
+		!ok1 || !ok2 || !ok3 || !ok4 || len(comment) > maxCommentLength || reqUserId != userId {
+		utils.WriteErrorJsonResponse(w, "invalid input")
+		return
+	}
+	id, ok := result["id"].(string)
+	if !ok && r.Method != http.MethodPost {
+		utils.WriteErrorJsonResponse(w, "invalid input")
+		return
+	}
+	// Spam and abuse checks, held comments are only shown to the author until approved
+	content := screening.Content{Kind: "comment", AuthorID: reqUserId, Text: comment}
+	screened := screening.Result{Verdict: screening.Allow}
+	if r.Method != http.MethodDelete {
+		if screened, ok = screenContent(w, content); !ok {
+			return
+		}
+	}
+	// held comments and edits are hidden in the same statement that writes them
+	var heldAt *time.Time
+	if screened.Verdict == screening.Hold {
+		now := time.Now().UTC()
+		heldAt = &now
+	}
+	var dealCommentId string
+	switch r.Method {
+	case http.MethodPost:
+		// reply to another unlocked comment of the same deal
+		var parentId *string
+		if parent, hasParent := result["parentId"].(string); hasParent {
+			if !utils.IsValidUUID(parent) {
+				utils.WriteErrorJsonResponse(w, "invalid parent")
+				return
+			}
+			parentId = &parent
+		}
+		err = env.Db.QueryRow(`INSERT INTO deal_comments(user_id, deal_id, comment_str, parent_id, held_at)
+			SELECT $1::uuid, $2::uuid, $3::text, $4::uuid, $5::timestamp
+			WHERE $4::uuid ISNULL OR EXISTS (SELECT 1 FROM deal_comments p
+				WHERE p.id = $4 AND p.deal_id = $2 AND p.removed_at ISNULL AND p.locked_at ISNULL
+				AND `+getNotBlockedFilter("p.user_id", "$1")+`)
+			RETURNING id`,
+			userId, dealId, comment, parentId, heldAt).Scan(&dealCommentId)
+		if err == sql.ErrNoRows {
+			utils.WriteErrorJsonResponse(w, "parent comment not found or locked")
+			return
+		}
+	case http.MethodPut:
+		dealCommentId, err = editDealComment(id, userId, comment, heldAt)
+	case http.MethodDelete:
+		// only by author, the deal poster hides comments instead, see moderateDealComment
+		err = env.Db.QueryRow(`UPDATE deal_comments SET removed_at = $1 WHERE id=$2 AND user_id=$3 RETURNING id`,
+			time.Now(), id, userId).Scan(&dealCommentId)
+	}
+	if err == sql.ErrNoRows {
+		w.WriteHeader(http.StatusForbidden)
+		utils.WriteErrorJsonResponse(w, "comment not found")
+		return
+	}
+	if err != nil {
+		utils.WriteErrorJsonResponse(w, err.Error())
+		return
+	}
+	if r.Method != http.MethodDelete {
+		recordScreening(content, dealCommentId, screened)
+	}
+	if r.Method != http.MethodDelete && screened.Verdict == screening.Allow {
+		notifyCommentMentions(dealId, dealCommentId, userId, comment)
+	}
+	utils.WriteJsonResponse(w, "commentId", dealCommentId)
+}
+
+// Updates text of the author's own unlocked comment, keeping the previous text in deal_comment_edits.
+// A held edit sets heldAt to hide the comment until approved.
+func editDealComment(commentId string, authorId string, comment string, heldAt *time.Time) (dealCommentId string, err error) {
+	tx, err := env.Db.Begin()
+	if err != nil {
+		return "", err
+	}
+	_, err = tx.Exec(`INSERT INTO deal_comment_edits(comment_id, comment_str, posted_at)
+		SELECT id, comment_str, COALESCE(edited_at, posted_at) FROM deal_comments
+		WHERE id = $1 AND user_id = $2 AND removed_at ISNULL AND locked_at ISNULL`, commentId, authorId)
+	if err != nil {
+		_ = tx.Rollback()
+		return "", err
+	}
+	err = tx.QueryRow(`UPDATE deal_comments SET comment_str = $1, edited_at = timezone('utc', now()),
+		held_at = COALESCE(held_at, $4::timestamp)
+		WHERE id = $2 AND user_id = $3 AND removed_at ISNULL AND locked_at ISNULL RETURNING id`,
+		comment, commentId, authorId, heldAt).Scan(&dealCommentId)
+	if err != nil {
+		_ = tx.Rollback()
+		return "", err
+	}
+	return dealCommentId, tx.Commit()
+}
+
+// Previous versions of a comment, most recent first, only of comments the viewer sees
+func getDealCommentEdits(w http.ResponseWriter, r *http.Request) {
+	commentId, err := getURLParamUUID("commentId", r)
+	if err != nil {
+		utils.WriteErrorJsonResponse(w, err.Error())
+		return
+	}
+	visible, err := isCommentVisible(r, commentId)
+	if err != nil {
+		utils.WriteErrorJsonResponse(w, err.Error())
+		return
+	}
+	if !visible {
+		w.WriteHeader(http.StatusNotFound)
+		utils.WriteErrorJsonResponse(w, "comment not found")
+		return
+	}
+	rows, err := env.Db.Query(`SELECT comment_str, posted_at, edited_at FROM deal_comment_edits
+		WHERE comment_id = $1 ORDER BY edited_at DESC`, commentId)
+	if err != nil {
+		utils.WriteErrorJsonResponse(w, err.Error())
+		return
+	}
+	defer utils.CloseRows(rows)
+	edits := []structs.DealCommentEdit{}
+	for rows.Next() {
+		var edit structs.DealCommentEdit
+		if err := rows.Scan(&edit.Comment, &edit.PostedAt, &edit.EditedAt); err != nil {
+			utils.WriteErrorJsonResponse(w, err.Error())
+			return
+		}
+		edits = append(edits, edit)
+	}
+	utils.WriteStructs(w, edits)
+}
+
+// Records @username mentions of a comment and notifies users mentioned for the first time in it
+func notifyCommentMentions(dealId string, commentId string, authorId string, comment string) {
+	var usernames []string
+	for _, match := range mentionRegexp.FindAllStringSubmatch(comment, -1) {
+		usernames = append(usernames, strings.ToLower(match[1]))
+	}
+	if len(usernames) == 0 {
+		return
+	}
+	rows, err := env.Db.Query(`INSERT INTO deal_comment_mentions AS d_cm (comment_id, user_id)
+		SELECT $1::uuid, u.id FROM users u WHERE lower(u.display_name) = ANY($2::text[]) AND u.id <> $3
+			AND `+getNotBlockedFilter("u.id", "$3")+`
+			AND NOT is_user_sanctioned($3, 'shadow')
+		ON CONFLICT ON CONSTRAINT deal_comment_mentions_comment_id_user_id_key DO NOTHING
+		RETURNING (SELECT u.fir_id FROM users u WHERE u.id = d_cm.user_id)`,
+		commentId, pq.Array(usernames), authorId)
+	if err != nil {
+		log.Printf("error saving mentions of comment '%s': %s", commentId, err)
+		return
+	}
+	defer utils.CloseRows(rows)
+	var firIds []string
+	for rows.Next() {
+		var firId sql.NullString
+		if err := rows.Scan(&firId); err != nil {
+			log.Printf("error reading mentions of comment '%s': %s", commentId, err)
+			return
+		}
+		if firId.Valid && firId.String != "" {
+			firIds = append(firIds, firId.String)
+		}
+	}
+	var authorName string
+	if err := env.Db.QueryRow(`SELECT display_name FROM users WHERE id = $1`, authorId).Scan(&authorName); err != nil {
+		log.Printf("error getting comment author '%s': %s", authorId, err)
+		return
+	}
+	pushNotifications(firIds, map[string]string{
+		"dealId":    dealId,
+		"commentId": commentId,
+		"kind":      "CommentMention",
+	}, fmt.Sprintf("%s mentioned you", authorName), comment)
+}
+
+// Deal poster's moderation of comments on their deal, body: {"action": "hide" | "unhide" | "pin" | ...}
+// Locked comments can't be edited or replied to, only top level comments can be pinned.
+func moderateDealComment(w http.ResponseWriter, r *http.Request) {
+	commentId, err := getURLParamUUID("commentId", r)
+	if err != nil {
+		utils.WriteErrorJsonResponse(w, err.Error())
+		return
+	}
+	result, err := utils.ReadRequestToJson(r)
+	if err != nil {
+		utils.WriteErrorJsonResponse(w, err.Error())
+		return
+	}
+	action, _ := result["action"].(string)
+	userId, ok := utils.GetUserIdInSession(r)
+	if !ok {
+		utils.WriteErrorJsonResponse(w, "invalid input")
+		return
+	}
+	var updateStr string
+	switch action {
+	case "hide":
+		updateStr = "hidden_at = timezone('utc', now())"
+	case "unhide":
+		updateStr = "hidden_at = NULL"
+	case "pin":
+		updateStr = "pinned_at = timezone('utc', now())"
+	case "unpin":
+		updateStr = "pinned_at = NULL"
+	case "lock":
+		updateStr = "locked_at = timezone('utc', now())"
+	case "unlock":
+		updateStr = "locked_at = NULL"
+	default:
+		utils.WriteErrorJsonResponse(w, fmt.Sprintf("invalid action '%s'", action))
+		return
+	}
+	pinFilter := ""
+	if action == "pin" {
+		pinFilter = " AND c.parent_id ISNULL"
+	}
+	var dealCommentId string
+	err = env.Db.QueryRow(`UPDATE deal_comments c SET `+updateStr+`
+		FROM deals d
+		WHERE c.id = $1 AND d.id = c.deal_id AND d.poster_id = $2 AND c.removed_at ISNULL`+pinFilter+`
+		RETURNING c.id`, commentId, userId).Scan(&dealCommentId)
+	if err == sql.ErrNoRows {
+		w.WriteHeader(http.StatusForbidden)
+		utils.WriteErrorJsonResponse(w, "comment not found")
+		return
+	}
+	if err != nil {
+		utils.WriteErrorJsonResponse(w, err.Error())
+		return
+	}
+	utils.WriteJsonResponse(w, "commentId", dealCommentId)
+}
+
+// Report a comment, body: {"reasonCode": "spam", "reason": "optional details"}, each user can report a comment once
+func reportDealComment(w http.ResponseWriter, r *http.Request) {
+	commentId, err := getURLParamUUID("commentId", r)
+	if err != nil {
+		utils.WriteErrorJsonResponse(w, err.Error())
+		return
+	}
+	writeContentReport(w, r, "comment", commentId)
+}
+
+// Moderation queue for the session user: reported comments on deals they posted, most reported first
+func getDealCommentReports(w http.ResponseWriter, r *http.Request) {
+	userId, ok := utils.GetUserIdInSession(r)
+	if !ok {
+		utils.WriteErrorJsonResponse(w, "invalid input")
+		return
+	}
+	rows, err := env.Db.Query(`SELECT c.id, c.deal_id, c.user_id, u.display_name, c.comment_str, c.posted_at,
+		c.hidden_at NOTNULL, COUNT(c_r.id), array_agg(c_r.reason), MAX(c_r.created_at)
+		FROM content_reports c_r
+		INNER JOIN deal_comments c ON c.id = c_r.target_id
+		INNER JOIN deals d ON d.id = c.deal_id
+		INNER JOIN users u ON u.id = c.user_id
+		WHERE c_r.target_type = 'comment' AND c_r.resolved_at ISNULL
+			AND d.poster_id = $1 AND c.removed_at ISNULL
+		GROUP BY c.id, u.display_name
+		ORDER BY COUNT(c_r.id) DESC, MAX(c_r.created_at) DESC`, userId)
+	if err != nil {
+		utils.WriteErrorJsonResponse(w, err.Error())
+		return
+	}
+	defer utils.CloseRows(rows)
+	reports := []structs.DealCommentReport{}
+	for rows.Next() {
+		var report structs.DealCommentReport
+		err = rows.Scan(&report.Comment.ID, &report.Comment.DealID, &report.Comment.UserID,
+			&report.Comment.Username, &report.Comment.Comment, &report.Comment.PostedAt,
+			&report.Comment.IsHidden, &report.Reports, pq.Array(&report.Reasons), &report.LastReportedAt)
+		if err != nil {
+			utils.WriteErrorJsonResponse(w, err.Error())
+			return
+		}
+		reports = append(reports, report)
+	}
+	utils.WriteStructs(w, reports)
+}
+
+// Reaction counts of comments, with viewerId's own reactions if given
+func getDealCommentReactions(commentIds []string, viewerId string) (map[string]structs.DealCommentReactions, error) {
+	reactions := make(map[string]structs.DealCommentReactions)
+	for _, commentId := range commentIds {
+		reactions[commentId] = structs.DealCommentReactions{Counts: map[string]uint{}, Mine: []string{}}
+	}
+	if len(commentIds) == 0 {
+		return reactions, nil
+	}
+	var viewer interface{}
+	if viewerId != "" {
+		viewer = viewerId
+	}
+	rows, err := env.Db.Query(`SELECT comment_id, reaction, COUNT(*), COALESCE(bool_or(user_id = $2), false)
+		FROM deal_comment_reactions
+		WHERE comment_id = ANY($1::uuid[])
+		GROUP BY comment_id, reaction`, pq.Array(commentIds), viewer)
+	if err != nil {
+		return nil, err
+	}
+	defer utils.CloseRows(rows)
+	for rows.Next() {
+		var commentId, reaction string
+		var count uint
+		var isMine bool
+		if err := rows.Scan(&commentId, &reaction, &count, &isMine); err != nil {
+			return nil, err
+		}
+		commentReactions := reactions[commentId]
+		commentReactions.Counts[reaction] = count
+		if isMine {
+			commentReactions.Mine = append(commentReactions.Mine, reaction)
+		}
+		reactions[commentId] = commentReactions
+	}
+	return reactions, rows.Err()
+}
+
+// Add (POST) or remove (DELETE) the session user's reaction, body: {"reaction": "like"}.
+// Responds with the comment's updated reactions.
+func handleDealCommentReaction(w http.ResponseWriter, r *http.Request) {
+	commentId, err := getURLParamUUID("commentId", r)
+	if err != nil {
+		utils.WriteErrorJsonResponse(w, err.Error())
+		return
+	}
+	result, err := utils.ReadRequestToJson(r)
+	if err != nil {
+		utils.WriteErrorJsonResponse(w, err.Error())
+		return
+	}
+	reaction, _ := result["reaction"].(string)
+	userId, ok := utils.GetUserIdInSession(r)
+	if !ok || !commentReactions[reaction] {
+		utils.WriteErrorJsonResponse(w, "invalid input")
+		return
+	}
+	switch r.Method {
+	case http.MethodPost:
+		// only on comments the user sees, so not on held or hidden ones or between blocked users
+		var visible bool
+		visible, err = isCommentVisible(r, commentId)
+		if err == nil && !visible {
+			w.WriteHeader(http.StatusNotFound)
+			utils.WriteErrorJsonResponse(w, "comment not found")
+			return
+		}
+		if err == nil {
+			_, err = env.Db.Exec(`INSERT INTO deal_comment_reactions (comment_id, user_id, reaction)
+				SELECT c.id, $2::uuid, $3::text FROM deal_comments c
+				WHERE c.id = $1 AND `+getCommentVisibleFilter("$2::uuid")+`
+				ON CONFLICT ON CONSTRAINT deal_comment_reactions_comment_id_user_id_reaction_key DO NOTHING`,
+				commentId, userId, reaction)
+		}
+	case http.MethodDelete:
+		_, err = env.Db.Exec(`DELETE FROM deal_comment_reactions
+			WHERE comment_id = $1 AND user_id = $2 AND reaction = $3`,
+			commentId, userId, reaction)
+	}
+	if err != nil {
+		utils.WriteErrorJsonResponse(w, err.Error())
+		return
+	}
+	reactions, err := getDealCommentReactions([]string{commentId}, userId)
+	if err != nil {
+		utils.WriteErrorJsonResponse(w, err.Error())
+		return
+	}
+	utils.WriteStructs(w, reactions[commentId])
+}
@@ -9,6 +9,7 @@ import (
 	"github.com/gorilla/mux"
 	"github.com/iancoleman/strcase"
 	"groupbuying.online/api/env"
+	"groupbuying.online/api/screening"
 	"groupbuying.online/api/structs"
 	"groupbuying.online/api/utils"
 	"log"
@@ -163,31 +164,26 @@ func getDeals(w http.ResponseWriter, r *http.Request) {
 	}
 	// END filter
 
-	selectCols := `SELECT d.id, d.title, d.description, d_i.image_url,
-		d.latitude, d.longitude, d.location_text, 
-		d.total_price, d.quantity, d.benefits,
-		d.category_id, d.poster_id, d.posted_at, 
-		d.updated_at, d.inactive_at,  d.featured_url,
-		(SELECT COUNT(CASE WHEN d_l.is_upvote THEN 1 END) FROM deal_likes d_l WHERE d.id=d_l.deal_id) as likes,
-		(SELECT COUNT(*) FROM deal_memberships d_m WHERE d.id=d_m.deal_id) as members
-	`
-	fromTables := ` FROM deals d LEFT JOIN deal_images d_i on d.id=d_i.deal_id`
-
+	var viewerId interface{}
 	reqUserId, hasSessionId := utils.GetUserIdInSession(r)
-	if reqUserId != "" && hasSessionId {
-		// deal is not hidden by user
-		filterHidden := fmt.Sprintf(
-			` NOT EXISTS (SELECT user_id FROM deal_hidden d_h
-			WHERE d_h.deal_id=d.id AND d_h.user_id='%s')`,
-			reqUserId)
-		filterStrings = append(filterStrings, filterHidden)
-
-		// poster is not blocked by user
-		filterBlocked := fmt.Sprintf(
-			` NOT EXISTS (SELECT user_id FROM users_blocked u_b 
-			WHERE u_b.blocked_id=d.poster_id AND u_b.user_id='%s')`,
-			reqUserId)
-		filterStrings = append(filterStrings, filterBlocked)
+	if hasSessionId {
+		viewerId = reqUserId
+	}
+	colCount++
+	viewerExpr := fmt.Sprintf("$%d::uuid", colCount)
+	queryParams = append(queryParams, viewerId)
+	selectCols := getDealSelectCols(viewerExpr)
+	fromTables := dealFromTables
+	filterStrings = append(filterStrings, getDealViewerFilters(viewerExpr)...)
+
+	// Favorites of session user, `favoritedBy=me`
+	if favoritedBy := values.Get("favoritedBy"); favoritedBy != "" {
+		if favoritedBy != "me" || !hasSessionId {
+			utils.WriteErrorJsonResponse(w, "invalid favoritedBy")
+			return
+		}
+		filterStrings = append(filterStrings,
+			"EXISTS (SELECT 1 FROM deal_favorites d_f WHERE d_f.deal_id=d.id AND d_f.user_id="+viewerExpr+")")
 	}
 
 	// In profile, get deals by those joined:
@@ -198,7 +194,6 @@ func getDeals(w http.ResponseWriter, r *http.Request) {
 		filterStrings = append(filterStrings, fmt.Sprintf("d_m.user_id='%s'", memberId))
 	}
 
-	var deals []structs.Deal
 	var rows *sql.Rows
 
 	// NOTE: Ensure all user-defined strings are in query parameters
@@ -208,6 +203,13 @@ func getDeals(w http.ResponseWriter, r *http.Request) {
 	if orderByColumn == "total_price" || orderByColumn == "posted_at" {
 		orderByColumn = "d." + orderByColumn
 	}
+	if orderByColumn == "hot" {
+		orderByColumn = "d.hot_score"
+	}
+	// lower bound of the upvote ratio, so deals with few votes are not ranked on luck
+	if orderByColumn == "best" {
+		orderByColumn = "wilson_lower_bound(d.likes_count, d.downvotes_count)"
+	}
 	orderByStr := fmt.Sprintf("ORDER BY %s %s", orderByColumn, orderByDirection)
 	limitStr := fmt.Sprintf("LIMIT %d", pageSize)
 	query := selectCols + fromTables + strings.Join([]string{filterStr, orderByStr, limitStr}, " ")
@@ -220,20 +222,71 @@ func getDeals(w http.ResponseWriter, r *http.Request) {
 	}
 
 	defer utils.CloseRows(rows)
+	deals, err := scanDealRows(rows)
+	if err != nil {
+		utils.WriteErrorJsonResponse(w, err.Error())
+		return
+	}
+	writeDeals(w, deals)
+}
+
+// Columns of deal listings read by scanDealRows, viewerExpr is the placeholder of the session user's id
+// for their own vote and favorite, NULL when logged out.
+func getDealSelectCols(viewerExpr string) string {
+	return `SELECT d.id, d.title, d.description, d_i.image_url,
+		d.latitude, d.longitude, d.location_text, 
+		d.total_price, d.quantity, d.benefits,
+		d.category_id, d.poster_id, d.posted_at, 
+		d.updated_at, d.inactive_at,  d.featured_url, d.hot_score,
+		d.likes_count, d.downvotes_count, d.likes_count - d.downvotes_count as likes,
+		d.members_count as members, d.comments_count, d.images_count,
+		(SELECT is_upvote FROM deal_likes d_l WHERE d.id=d_l.deal_id AND d_l.user_id=` + viewerExpr + `) as my_vote,
+		CASE WHEN ` + viewerExpr + ` NOTNULL THEN EXISTS (SELECT 1 FROM deal_favorites d_f
+			WHERE d.id=d_f.deal_id AND d_f.user_id=` + viewerExpr + `) END as is_favorited`
+}
+
+const dealFromTables = ` FROM deals d LEFT JOIN deal_images d_i on d.thumbnail_id=d_i.id`
+
+// Filters out deals the session user should not see in listings,
+// viewerExpr is the placeholder of their id as in getDealSelectCols
+func getDealViewerFilters(viewerExpr string) []string {
+	// deal is not hidden by moderation
+	filterModerated := "d.moderated_at ISNULL AND d.held_at ISNULL"
+
+	// poster is not shadow banned, or is the user
+	filterShadowBanned := getNotShadowBannedFilter("d.poster_id", viewerExpr)
+
+	// deal is not hidden by user
+	filterHidden := ` NOT EXISTS (SELECT user_id FROM deal_hidden d_h
+		WHERE d_h.deal_id=d.id AND d_h.user_id=` + viewerExpr + `)`
+
+	// poster and user have not blocked each other
+	filterBlocked := getNotBlockedFilter("d.poster_id", viewerExpr)
+	return []string{filterModerated, filterShadowBanned, filterHidden, filterBlocked}
+}
+
+func scanDealRows(rows *sql.Rows) (deals []structs.Deal, err error) {
 	for rows.Next() {
 		var deal structs.Deal
+		var votes structs.DealVoteSummary
 		err = rows.Scan(&deal.ID, &deal.Title, &deal.Description, &deal.ThumbnailUrl,
 			&deal.Latitude, &deal.Longitude, &deal.LocationText,
 			&deal.TotalPrice, &deal.Quantity, &deal.Benefits,
 			&deal.CategoryID, &deal.PosterID, &deal.PostedAt,
-			&deal.UpdatedAt, &deal.InactiveAt, &deal.FeaturedUrl,
-			&deal.Likes, &deal.Members)
+			&deal.UpdatedAt, &deal.InactiveAt, &deal.FeaturedUrl, &deal.HotScore,
+			&votes.Upvotes, &votes.Downvotes, &deal.Likes,
+			&deal.Members, &deal.Comments, &deal.Images, &votes.MyVote, &deal.IsFavorited)
 		if err != nil {
-			utils.WriteErrorJsonResponse(w, err.Error())
-			return
+			return nil, err
 		}
+		votes.Net = int(votes.Upvotes) - int(votes.Downvotes)
+		deal.Votes = &votes
 		deals = append(deals, deal)
 	}
+	return deals, rows.Err()
+}
+
+func writeDeals(w http.ResponseWriter, deals []structs.Deal) {
 	dealArr, err := json.Marshal(deals)
 	if len(deals) == 0 {
 		dealArr = []byte("[]")
@@ -261,10 +314,16 @@ func GetDeal(w http.ResponseWriter, r *http.Request) {
 		category_id, poster_id, posted_at, 
 		updated_at, inactive_at FROM deals`
 
-	filterStr := fmt.Sprintf(" WHERE id = $1")
+	// deals hidden by moderation, held by screening or of shadow banned posters are only shown to their poster
+	filterStr := " WHERE id = $1 AND ((moderated_at ISNULL AND held_at ISNULL) OR poster_id = $2) AND " +
+		getNotShadowBannedFilter("poster_id", "$2")
 	query := selectCols + filterStr
+	var viewerId interface{}
+	if userId, ok := utils.GetUserIdInSession(r); ok {
+		viewerId = userId
+	}
 	var deal structs.Deal
-	err = env.Db.QueryRow(query, dealId).Scan(
+	err = env.Db.QueryRow(query, dealId, viewerId).Scan(
 		&deal.Title, &deal.Description, &deal.ThumbnailUrl,
 		&deal.Latitude, &deal.Longitude, &deal.LocationText,
 		&deal.TotalPrice, &deal.Quantity, &deal.Benefits,
@@ -272,9 +331,17 @@ func GetDeal(w http.ResponseWriter, r *http.Request) {
 		&deal.UpdatedAt, &deal.InactiveAt)
 	if err != nil {
 		utils.WriteErrorJsonResponse(w, err.Error())
-	} else {
-		utils.WriteStructs(w, deal)
+		return
+	}
+	userId, _ := utils.GetUserIdInSession(r)
+	votes, err := getDealVoteSummary(dealId, userId)
+	if err != nil {
+		utils.WriteErrorJsonResponse(w, err.Error())
+		return
 	}
+	deal.ID = dealId
+	deal.Votes = &votes
+	utils.WriteStructs(w, deal)
 }
 
 func getDealCategories(w http.ResponseWriter, r *http.Request) {
@@ -368,13 +435,26 @@ func postDeal(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// deals are only posted as the session user, so sanctions on them can't be bypassed
 	posterId := colValues["poster_id"].(string)
-	if !utils.IsValidUUID(posterId) {
+	reqUserId, ok := utils.GetUserIdInSession(r)
+	if !utils.IsValidUUID(posterId) || !ok || reqUserId != posterId {
 		utils.WriteErrorJsonResponse(w, "invalid user id")
 		return
 	}
 	// END Validations
 
+	// Spam and abuse checks, held deals are only shown to the poster until approved
+	content := screening.Content{Kind: "deal", AuthorID: reqUserId,
+		Title: colValues["title"].(string), Text: colValues["description"].(string)}
+	screened, ok := screenContent(w, content)
+	if !ok {
+		return
+	}
+	if screened.Verdict == screening.Hold {
+		colValues["held_at"] = time.Now().UTC()
+	}
+
 	var cols []string
 	var vals []interface{}
 	for col, val := range colValues {
@@ -403,6 +483,7 @@ func postDeal(w http.ResponseWriter, r *http.Request) {
 	var dealId string
 	err = env.Db.QueryRow(query, vals...).Scan(&dealId)
 	utils.CheckFatalError(w, err)
+	recordScreening(content, dealId, screened)
 
 	// Insert membership
 	var membershipId string
@@ -494,6 +575,17 @@ func UpdateDeal(w http.ResponseWriter, r *http.Request) {
 		utils.WriteErrorJsonResponse(w, fmt.Sprintf("Invalid value '%s'", val))
 		return
 	}
+	// Edits are screened like new deals, a held edit hides the deal until approved
+	title, _ := colValues["title"].(string)
+	description, _ := colValues["description"].(string)
+	content := screening.Content{Kind: "deal", AuthorID: userId, Title: title, Text: description}
+	screened, ok := screenContent(w, content)
+	if !ok {
+		return
+	}
+	if screened.Verdict == screening.Hold {
+		colValues["held_at"] = time.Now().UTC()
+	}
 	// Insert thumbnail image of deal id if doesn't exist
 	var thumbnailImageId string
 	err = env.Db.QueryRow("UPDATE deal_images SET image_url=$1 WHERE (deal_id=$2 AND poster_id=$3) RETURNING id",
@@ -532,17 +624,44 @@ func UpdateDeal(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	query := fmt.Sprintf(`UPDATE deals SET %s WHERE id=$%d AND poster_id=$%d RETURNING id`,
+	before, err := getAuditSnapshot(env.Db, "deal", dealId)
+	if err != nil {
+		utils.WriteErrorJsonResponse(w, err.Error())
+		return
+	}
+	// Previous price, to tell favorites about price changes
+	var prevPrice sql.NullFloat64
+	err = env.Db.QueryRow(`SELECT total_price FROM deals WHERE id=$1`, dealId).Scan(&prevPrice)
+	if err != nil {
+		utils.WriteErrorJsonResponse(w, err.Error())
+		return
+	}
+
+	query := fmt.Sprintf(`UPDATE deals SET %s WHERE id=$%d AND poster_id=$%d RETURNING id, title`,
 		updateStr, len(colValues)+1, len(colValues)+2)
 	queryValues = append(queryValues, dealId)
 	queryValues = append(queryValues, userId)
 	var dealIdReturned string
-	err = env.Db.QueryRow(query, queryValues...).Scan(&dealIdReturned)
+	err = env.Db.QueryRow(query, queryValues...).Scan(&dealIdReturned, &title)
 	if err != nil {
 		utils.WriteErrorJsonResponse(w, err.Error())
-	} else {
-		utils.WriteJsonResponse(w, "dealId", dealId)
+		return
+	}
+	recordScreening(content, dealId, screened)
+	after, _ := getAuditSnapshot(env.Db, "deal", dealId)
+	recordAudit(r, auditEntry{actorId: userId, action: "deal.update", targetType: "deal", targetId: dealId,
+		before: before, after: after})
+	price, hasPrice := colValues["total_price"].(float64)
+	priceChanged := hasPrice != prevPrice.Valid || (hasPrice && price != prevPrice.Float64)
+	if priceChanged && screened.Verdict == screening.Allow {
+		priceText := "no longer has a price"
+		if hasPrice {
+			priceText = fmt.Sprintf("is now %.2f", price)
+		}
+		notifyDealFavorites(dealId, userId, "FavoriteDealPriceChanged",
+			fmt.Sprintf("Price changed for %s", title), fmt.Sprintf("The price %s", priceText))
 	}
+	utils.WriteJsonResponse(w, "dealId", dealId)
 }
 
 func getURLParamUUID(paramName string, r *http.Request) (string, error) {
@@ -572,11 +691,18 @@ func SetInactiveDeal(w http.ResponseWriter, r *http.Request) {
 		utils.WriteErrorJsonResponse(w, err.Error())
 		return
 	}
-	err = env.Db.QueryRow(`UPDATE deals SET inactive_at = $1 WHERE id = $2 AND poster_id=$3 RETURNING id`,
-		time.Now(), dealId, userId).Scan(&dealId)
+	var title string
+	before, _ := getAuditSnapshot(env.Db, "deal", dealId)
+	err = env.Db.QueryRow(`UPDATE deals SET inactive_at = $1 WHERE id = $2 AND poster_id=$3 RETURNING id, title`,
+		time.Now(), dealId, userId).Scan(&dealId, &title)
 	if err != nil {
 		utils.WriteErrorJsonResponse(w, err.Error())
 	} else {
+		after, _ := getAuditSnapshot(env.Db, "deal", dealId)
+		recordAudit(r, auditEntry{actorId: userId, action: "deal.delete", targetType: "deal", targetId: dealId,
+			before: before, after: after})
+		notifyDealFavorites(dealId, userId, "FavoriteDealClosed",
+			fmt.Sprintf("%s has closed", title), "A deal you favorited is no longer available")
 		utils.WriteSuccessJsonResponse(w, "deal removed")
 	}
 }
@@ -609,6 +735,11 @@ func getDealMembersByDealId(w http.ResponseWriter, r *http.Request) {
 	}
 	var dealMembers []structs.DealMembership
 	var rows *sql.Rows
+	// members the session user blocked or was blocked by are left out
+	var viewerId interface{}
+	if reqUserId, ok := utils.GetUserIdInSession(r); ok {
+		viewerId = reqUserId
+	}
 	if base != "" {
 		iso8601Layout := "2006-01-02T15:04:05Z"
 		baseT, err := time.Parse(iso8601Layout, base)
@@ -616,21 +747,21 @@ func getDealMembersByDealId(w http.ResponseWriter, r *http.Request) {
 			utils.WriteErrorJsonResponse(w, "Wrong time")
 			return
 		}
-		rows, err = env.Db.Query(`SELECT u.id, u.display_name, u.image_url, joined_at, u.fir_id
+		rows, err = env.Db.Query(`SELECT u.id, u.display_name, u.image_url, joined_at, COALESCE(u.fir_id, '')
 		FROM users u INNER JOIN deal_memberships m 
 		ON u.id = m.user_id 
-		WHERE m.deal_id = $1 AND m.joined_at > $2
+		WHERE m.deal_id = $1 AND m.joined_at > $2 AND `+getNotBlockedFilter("u.id", "$4::uuid")+`
 		ORDER BY joined_at
 		LIMIT $3;
-		`, dealId, baseT, limitI)
+		`, dealId, baseT, limitI, viewerId)
 	} else {
-		rows, err = env.Db.Query(`SELECT u.id, u.display_name, u.image_url, joined_at, u.fir_id
+		rows, err = env.Db.Query(`SELECT u.id, u.display_name, u.image_url, joined_at, COALESCE(u.fir_id, '')
 		FROM users u INNER JOIN deal_memberships m 
 		ON u.id = m.user_id 
-		WHERE m.deal_id = $1 
+		WHERE m.deal_id = $1 AND `+getNotBlockedFilter("u.id", "$3::uuid")+`
 		ORDER BY joined_at
 		LIMIT $2;
-		`, dealId, limitI)
+		`, dealId, limitI, viewerId)
 	}
 	defer utils.CloseRows(rows)
 	for rows.Next() {
@@ -667,12 +798,16 @@ func handleDealMembership(w http.ResponseWriter, r *http.Request) {
 		if err == nil {
 			log.Print(fmt.Sprintf("Updated membership for user '%s' in deal '%s' in %s",
 				userId, dealId, dealMembershipId))
+			notifyDealNearFull(dealId, userId)
+			recordAudit(r, auditEntry{actorId: userId, action: "membership.join", targetType: "deal", targetId: dealId,
+				details: map[string]interface{}{"membershipId": dealMembershipId}})
 			utils.WriteSuccessJsonResponse(w, "Updated membership")
 		}
 	case http.MethodDelete:
 		err = LeaveDeal(dealId, userId)
 		if err == nil {
 			log.Print(fmt.Sprintf("Removed membership for user '%s' in deal '%s'", userId, dealId))
+			recordAudit(r, auditEntry{actorId: userId, action: "membership.leave", targetType: "deal", targetId: dealId})
 			utils.WriteSuccessJsonResponse(w, "Removed membership")
 		}
 	default: utils.WriteErrorJsonResponse(w, fmt.Sprintf("Method not supported %s", r.Method))
@@ -730,8 +865,9 @@ func handleDealImage(w http.ResponseWriter, r *http.Request) {
 		dealId := result["dealId"].(string)
 		imageUrl := result["imageUrl"].(string)
 		posterId := result["posterId"].(string)
+		reqUserId, ok := utils.GetUserIdInSession(r)
 		_, err := url.Parse(imageUrl)
-		if !utils.IsValidUUID(dealId) || !utils.IsValidUUID(posterId) || err != nil {
+		if !utils.IsValidUUID(dealId) || !utils.IsValidUUID(posterId) || err != nil || !ok || reqUserId != posterId {
 			utils.WriteErrorJsonResponse(w, "invalid id")
 			return
 		}
@@ -765,25 +901,36 @@ func getDealLikeByUserId(w http.ResponseWriter, r *http.Request) {
 
 func getDealLikeSummaryByDealId(w http.ResponseWriter, r *http.Request) {
 	dealId, err := getURLParamUUID("dealId", r)
-	utils.CheckFatalError(w, err)
-	var upVotes int
-	var downVotes int
-	err = env.Db.QueryRow(`SELECT 
-		count(nullif(is_upvote = true, true)),
-		count(nullif(is_upvote = false, true))
-		FROM deal_likes
-		WHERE deal_id = $1`, dealId).Scan(&upVotes, &downVotes)
-	type result struct {
-		upVotes int
-		downVotes int
-	}
-	res := &result{upVotes: upVotes, downVotes: downVotes}
-	resStr, err := json.Marshal(res)
 	if err != nil {
-		utils.WriteErrorJsonResponse(w, "invalid json")
+		utils.WriteErrorJsonResponse(w, err.Error())
+		return
 	}
-	_, err = w.Write(resStr)
-	utils.CheckFatalError(w, err)
+	userId, _ := utils.GetUserIdInSession(r)
+	votes, err := getDealVoteSummary(dealId, userId)
+	if err != nil {
+		utils.WriteErrorJsonResponse(w, err.Error())
+		return
+	}
+	utils.WriteStructs(w, votes)
+}
+
+// Vote counts of a deal, with userId's own vote if userId is given
+func getDealVoteSummary(dealId string, userId string) (votes structs.DealVoteSummary, err error) {
+	err = env.Db.QueryRow(`SELECT likes_count, downvotes_count FROM deals WHERE id = $1`,
+		dealId).Scan(&votes.Upvotes, &votes.Downvotes)
+	if err != nil {
+		return votes, err
+	}
+	votes.Net = int(votes.Upvotes) - int(votes.Downvotes)
+	if userId == "" {
+		return votes, nil
+	}
+	err = env.Db.QueryRow("SELECT is_upvote FROM deal_likes WHERE deal_id=$1 AND user_id=$2",
+		dealId, userId).Scan(&votes.MyVote)
+	if err == sql.ErrNoRows {
+		err = nil
+	}
+	return votes, err
 }
 
 func handleDealLike(w http.ResponseWriter, r *http.Request) {
@@ -816,64 +963,31 @@ func handleDealLike(w http.ResponseWriter, r *http.Request) {
 		fmt.Sprintf("Updated user '%s' like status for deal '%s'", userId, dealId))
 }
 
-func getDealCommentsByDealId(w http.ResponseWriter, r *http.Request)  {
-	dealId, err := getURLParamUUID("dealId", r)
-	utils.CheckFatalError(w, err)
-	var dealComments []structs.DealComment
-	rows, err := env.Db.Query(
-		`SELECT d.id, d.user_id, u.fir_id, u.display_name, d.comment_str, d.posted_at 
- 		FROM deal_comments d
- 		INNER JOIN users u ON u.id = d.user_id 
-		WHERE removed_at ISNULL AND deal_id = $1`,
-		dealId)
-	defer utils.CloseRows(rows)
-	for rows.Next() {
-		var dealComment structs.DealComment
-		err = rows.Scan(&dealComment.ID, &dealComment.UserID, &dealComment.UserFIRID,
-			&dealComment.Username, &dealComment.Comment, &dealComment.PostedAt)
-		dealComments = append(dealComments, dealComment)
-	}
-	utils.WriteStructs(w, dealComments)
-}
-
-func handleDealComment(w http.ResponseWriter, r *http.Request) {
+func hideDeal(w http.ResponseWriter, r *http.Request) {
 	result, err := utils.ReadRequestToJson(r)
 	utils.CheckFatalError(w, err)
 	dealId, ok1 := result["dealId"].(string)
 	userId, ok2 := result["userId"].(string)
-	comment, ok3 := result["comment"].(string)
-	reqUserId, ok4 := utils.GetUserIdInSession(r)
+	reqUserId, ok3 := utils.GetUserIdInSession(r)
 
 	if !utils.IsValidUUID(dealId) || !utils.IsValidUUID(userId) ||
-		!ok1 || !ok2 || !ok3 || !ok4 || len(comment) > 240 || reqUserId != userId {
-		utils.WriteErrorJsonResponse(w, "invalid input")
-		return
-	}
-	id, ok := result["id"].(string)
-	if !ok && r.Method != http.MethodPost {
-		utils.WriteErrorJsonResponse(w, "invalid input")
+		!ok1 || !ok2 || !ok3 || reqUserId != userId {
+		utils.WriteErrorJsonResponse(w,"invalid input")
 		return
 	}
-	var dealCommentId string
+	var dealHiddenId string
 	switch r.Method {
 	case http.MethodPost:
-		err = env.Db.QueryRow(`INSERT INTO deal_comments(user_id, deal_id, comment_str) VALUES($1, $2, $3) RETURNING id`,
-			userId, dealId, comment).Scan(&dealCommentId)
-	case http.MethodPut:
-		err = env.Db.QueryRow(`UPDATE deal_comments SET comment_str = $1 WHERE id = $2 RETURNING id`,
-			comment, id).Scan(&dealCommentId)
+		err = env.Db.QueryRow(`INSERT INTO deal_hidden(user_id, deal_id) VALUES ($1, $2) RETURNING deal_id`,
+			userId, dealId).Scan(&dealHiddenId)
 	case http.MethodDelete:
-		err = env.Db.QueryRow(`UPDATE deal_comments SET removed_at = $1 WHERE id=$2 RETURNING id`,
-			time.Now(), id).Scan(&dealCommentId)
-	}
-	if err != nil {
-		utils.WriteErrorJsonResponse(w, err.Error())
-	} else {
-		utils.WriteJsonResponse(w, "commentId", dealCommentId)
+		err = env.Db.QueryRow(`DELETE from deal_hidden WHERE user_id = $1 AND deal_id = $2 RETURNING deal_id`,
+			userId, dealId).Scan(&dealHiddenId)
 	}
+	utils.CheckFatalError(w, err)
+	utils.WriteSuccessJsonResponse(w, dealHiddenId)
 }
-
-func hideDeal(w http.ResponseWriter, r *http.Request) {
+func handleDealFavorite(w http.ResponseWriter, r *http.Request) {
 	result, err := utils.ReadRequestToJson(r)
 	utils.CheckFatalError(w, err)
 	dealId, ok1 := result["dealId"].(string)
@@ -885,15 +999,20 @@ func hideDeal(w http.ResponseWriter, r *http.Request) {
 		utils.WriteErrorJsonResponse(w,"invalid input")
 		return
 	}
-	var dealHiddenId string
+	var dealFavoriteId string
 	switch r.Method {
 	case http.MethodPost:
-		err = env.Db.QueryRow(`INSERT INTO deal_hidden(user_id, deal_id) VALUES ($1, $2) RETURNING deal_id`,
-			userId, dealId).Scan(&dealHiddenId)
+		err = env.Db.QueryRow(`INSERT INTO deal_favorites(user_id, deal_id) VALUES ($1, $2)
+			ON CONFLICT ON CONSTRAINT deal_favorites_user_id_deal_id_key DO UPDATE SET user_id = $1
+			RETURNING deal_id`,
+			userId, dealId).Scan(&dealFavoriteId)
 	case http.MethodDelete:
-		err = env.Db.QueryRow(`DELETE from deal_hidden WHERE user_id = $1 AND deal_id = $2 RETURNING deal_id`,
-			userId, dealId).Scan(&dealHiddenId)
+		err = env.Db.QueryRow(`DELETE FROM deal_favorites WHERE user_id = $1 AND deal_id = $2 RETURNING deal_id`,
+			userId, dealId).Scan(&dealFavoriteId)
 	}
-	utils.CheckFatalError(w, err)
-	utils.WriteSuccessJsonResponse(w, dealHiddenId)
-}
\ No newline at end of file
+	if err != nil {
+		utils.WriteErrorJsonResponse(w, err.Error())
+		return
+	}
+	utils.WriteSuccessJsonResponse(w, dealFavoriteId)
+}
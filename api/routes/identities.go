@@ -0,0 +1,140 @@
+package routes
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/gorilla/mux"
+	"groupbuying.online/api/auth"
+	"groupbuying.online/api/env"
+	"groupbuying.online/api/identity"
+	"groupbuying.online/api/structs"
+	"groupbuying.online/api/utils"
+	"net/http"
+)
+
+// Links the identity of verified sign in claims to userId, sql.ErrNoRows if it is linked to an account
+// or the user has another identity of the provider
+func insertUserIdentity(db sqlRunner, userId string, claims identity.Identity) error {
+	var id int
+	return db.QueryRow(`INSERT INTO user_identities (user_id, provider, subject, email)
+		VALUES ($1, $2, $3, $4) ON CONFLICT DO NOTHING RETURNING id`,
+		userId, claims.Provider, claims.Subject, claims.Email).Scan(&id)
+}
+
+// Account changes need the user to sign in again with one of their linked identities,
+// body: {"currentProvider": "google", "currentToken": "..."}, or {"currentProvider": "password", "currentPassword": "..."}.
+// Writes the error response if they did not.
+func verifyReauthentication(w http.ResponseWriter, r *http.Request, userId string, result utils.UnstructuredJSON) bool {
+	provider, _ := result["currentProvider"].(string)
+	if provider == "password" {
+		password, _ := result["currentPassword"].(string)
+		var hash sql.NullString
+		err := env.Db.QueryRow(`SELECT password_hash FROM users WHERE id = $1`, userId).Scan(&hash)
+		if err == nil {
+			err = auth.CheckPassword(hash.String, password)
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusForbidden)
+			utils.WriteErrorJsonResponse(w, err.Error())
+			return false
+		}
+		return true
+	}
+	token, _ := result["currentToken"].(string)
+	claims, ok := verifyIdentity(w, r, provider, token)
+	if !ok {
+		return false
+	}
+	user, err := getUserByIdentity(claims)
+	if err != nil || user.ID != userId {
+		w.WriteHeader(http.StatusForbidden)
+		utils.WriteErrorJsonResponse(w, "sign in again with a sign in method of this account")
+		return false
+	}
+	return true
+}
+
+// Sign in methods of the user
+func getUserIdentities(w http.ResponseWriter, r *http.Request) {
+	userId, _ := utils.GetUserIdInSession(r)
+	rows, err := env.Db.Query(`SELECT provider, email, linked_at FROM user_identities
+		WHERE user_id = $1 ORDER BY linked_at`, userId)
+	if err != nil {
+		utils.WriteErrorJsonResponse(w, err.Error())
+		return
+	}
+	defer utils.CloseRows(rows)
+	identities := []structs.UserIdentity{}
+	for rows.Next() {
+		var userIdentity structs.UserIdentity
+		if err = rows.Scan(&userIdentity.Provider, &userIdentity.Email, &userIdentity.LinkedAt); err != nil {
+			utils.WriteErrorJsonResponse(w, err.Error())
+			return
+		}
+		identities = append(identities, userIdentity)
+	}
+	utils.WriteStructs(w, identities)
+}
+
+// Link another sign in method, body: {"provider": "google", "token": "...", "currentProvider": ..., "currentToken": ...}
+// with the token of the new method and a fresh one of a linked method, see verifyReauthentication
+func linkUserIdentity(w http.ResponseWriter, r *http.Request) {
+	result, err := utils.ReadRequestToJson(r)
+	if err != nil {
+		utils.WriteErrorJsonResponse(w, err.Error())
+		return
+	}
+	userId, _ := utils.GetUserIdInSession(r)
+	if !verifyReauthentication(w, r, userId, result) {
+		return
+	}
+	provider, _ := result["provider"].(string)
+	token, _ := result["token"].(string)
+	claims, ok := verifyIdentity(w, r, provider, token)
+	if !ok {
+		return
+	}
+	err = insertUserIdentity(env.Db, userId, claims)
+	if err == sql.ErrNoRows {
+		w.WriteHeader(http.StatusConflict)
+		utils.WriteErrorJsonResponse(w, fmt.Sprintf("%s sign in already linked to an account", provider))
+		return
+	}
+	if err != nil {
+		utils.WriteErrorJsonResponse(w, err.Error())
+		return
+	}
+	recordAudit(r, auditEntry{actorId: userId, action: "identity.link", targetType: "user", targetId: userId,
+		details: map[string]interface{}{"provider": provider, "email": claims.Email}})
+	utils.WriteSuccessJsonResponse(w, provider)
+}
+
+// Unlink a sign in method, body: {"currentProvider": ..., "currentToken": ...}, see verifyReauthentication.
+// The last one can not be unlinked unless the user has a password.
+func unlinkUserIdentity(w http.ResponseWriter, r *http.Request) {
+	provider := mux.Vars(r)["provider"]
+	result, err := utils.ReadRequestToJson(r)
+	if err != nil {
+		utils.WriteErrorJsonResponse(w, err.Error())
+		return
+	}
+	userId, _ := utils.GetUserIdInSession(r)
+	if !verifyReauthentication(w, r, userId, result) {
+		return
+	}
+	res, err := env.Db.Exec(`DELETE FROM user_identities
+		WHERE user_id = $1 AND provider = $2
+		AND ((SELECT COUNT(*) FROM user_identities WHERE user_id = $1) > 1
+			OR (SELECT password_hash NOTNULL FROM users WHERE id = $1))`, userId, provider)
+	if err != nil {
+		utils.WriteErrorJsonResponse(w, err.Error())
+		return
+	}
+	if unlinked, _ := res.RowsAffected(); unlinked == 0 {
+		utils.WriteErrorJsonResponse(w, fmt.Sprintf("%s sign in not linked or the only one left", provider))
+		return
+	}
+	recordAudit(r, auditEntry{actorId: userId, action: "identity.unlink", targetType: "user", targetId: userId,
+		details: map[string]interface{}{"provider": provider}})
+	utils.WriteSuccessJsonResponse(w, provider)
+}
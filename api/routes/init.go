@@ -5,25 +5,78 @@ import (
 	"github.com/gorilla/mux"
 	"google.golang.org/appengine"
 	"groupbuying.online/api/env"
+	"groupbuying.online/api/identity"
+	"groupbuying.online/api/mail"
 	"groupbuying.online/api/middleware"
+	"groupbuying.online/api/screening"
 
 	"log"
 	"net/http"
 )
 
 func InitRouter() {
+	contentScreener = screening.New(env.Conf.Screening, env.Db)
+	identityProviders = identity.New(env.Conf)
+	mailer = mail.New(env.Conf.Mail)
 	router := mux.NewRouter()
 	router.HandleFunc("/heartbeat", heartbeat).Methods(http.MethodGet)
 
+	// Scheduled jobs, see cron.yaml
+	cron := router.PathPrefix("/cron").Subrouter()
+	cronAuth := middleware.GetCronMiddleware(env.Conf)
+	cron.HandleFunc("/deals/hot_scores", middleware.Use(updateDealHotScores, cronAuth)).Methods(http.MethodGet)
+	cron.HandleFunc("/deals/counters", middleware.Use(repairDealCounters, cronAuth)).Methods(http.MethodGet)
+	cron.HandleFunc("/deals/recommendations", middleware.Use(updateDealRecommendations, cronAuth)).Methods(http.MethodGet)
+	cron.HandleFunc("/audit_log/retention", middleware.Use(purgeAuditLog, cronAuth)).Methods(http.MethodGet)
+
+	// budgets by route group in config, runs before auth
+	limits := middleware.NewMemoryRateLimitStore()
+	limit := func(group string) middleware.Middleware {
+		return middleware.GetRateLimitMiddleware(env.Store, env.Conf, env.Sessions, limits, group)
+	}
+
+	// Bearer token clients hold no session cookie, so these are registered before the csrf checked /api routes
+	tokens := router.PathPrefix("/api/token").Subrouter()
+	tokens.HandleFunc("/refresh", middleware.Use(refreshAuthTokens, limit("login"))).Methods(http.MethodPost)
+	tokens.HandleFunc("/revoke", middleware.Use(revokeAuthToken, limit("login"))).Methods(http.MethodPost)
+
+	// Sign in routes are called before the client has a session or bearer token, so they aren't csrf checked either
+	signIn := router.PathPrefix("/api").Subrouter()
+	signIn.HandleFunc("/register/email", middleware.Use(registerEmailUser, limit("register"))).Methods(http.MethodPost)
+	signIn.HandleFunc("/register/social_media", middleware.Use(registerBySocialMedia, limit("register"))).Methods(http.MethodPost)
+	signIn.HandleFunc("/login/email", middleware.Use(loginEmailUser, limit("login"))).Methods(http.MethodPost)
+	signIn.HandleFunc("/register/password", middleware.Use(registerPasswordUser, limit("register"))).Methods(http.MethodPost)
+	signIn.HandleFunc("/login/password", middleware.Use(loginPasswordUser, limit("login"))).Methods(http.MethodPost)
+	signIn.HandleFunc("/login/totp", middleware.Use(loginTOTP, limit("login"))).Methods(http.MethodPost)
+	signIn.HandleFunc("/email/verify", middleware.Use(verifyEmail, limit("login"))).Methods(http.MethodPost)
+	signIn.HandleFunc("/email/verify/resend", middleware.Use(resendVerificationEmail, limit("register"))).Methods(http.MethodPost)
+	signIn.HandleFunc("/password/reset/request", middleware.Use(requestPasswordReset, limit("register"))).Methods(http.MethodPost)
+	signIn.HandleFunc("/password/reset", middleware.Use(resetPassword, limit("login"))).Methods(http.MethodPost)
+	signIn.HandleFunc("/login/facebook", middleware.Use(loginFacebookUser, limit("login"))).Methods(http.MethodPost)
+	signIn.HandleFunc("/login/google", middleware.Use(loginGoogleUser, limit("login"))).Methods(http.MethodPost)
+
 	api := router.PathPrefix("/api").Subrouter()
-	auth := middleware.GetAuthMiddleware(env.Store, env.Conf)
+	// every state changing api request needs the token from /api/csrf_token
+	csrf := middleware.GetCSRFMiddleware(env.Store, env.Conf)
+	api.Use(func(next http.Handler) http.Handler {
+		return csrf(next.ServeHTTP)
+	})
+	api.HandleFunc("/csrf_token", getCSRFToken).Methods(http.MethodGet)
+	auth := middleware.GetAuthMiddleware(env.Store, env.Conf, env.Db, env.Sessions)
+	// content creating routes, runs after auth
+	posting := middleware.GetPostingMiddleware(env.Db)
+	moderator := middleware.GetRoleMiddleware(env.Db, "moderator")
+	admin := middleware.GetRoleMiddleware(env.Db, "admin")
 
 	// Deal
 	api.HandleFunc("/deals", getDeals).Methods(http.MethodGet)
-	api.HandleFunc("/deals", middleware.Use(postDeal, auth)).Methods(http.MethodPost)
+	api.HandleFunc("/deals", middleware.Use(postDeal, posting, auth, limit("postDeal"))).Methods(http.MethodPost)
 	api.HandleFunc("/deals/categories", getDealCategories).Methods(http.MethodGet)
+	api.HandleFunc("/deals/for_you", middleware.Use(getDealsForYou, auth)).Methods(http.MethodGet)
 
-	api.HandleFunc("/deal/{dealId}", middleware.Use(handleDeal, auth)).Methods(http.MethodGet, http.MethodPut, http.MethodDelete)
+	api.HandleFunc("/deal/{dealId}", middleware.Use(handleDeal, posting, auth)).Methods(http.MethodGet, http.MethodPut, http.MethodDelete)
+	api.HandleFunc("/deal/{dealId}/similar", getSimilarDeals).Methods(http.MethodGet)
+	api.HandleFunc("/deal/{dealId}/report", middleware.Use(reportDeal, auth)).Methods(http.MethodPost)
 
 	api.HandleFunc("/deal/{dealId}/memberships", getDealMembersByDealId).Methods(http.MethodGet)
 	api.HandleFunc("/deal/{dealId}/membership/{userId}", getDealMembershipByUserIdDealId).Methods(http.MethodGet)
@@ -34,34 +87,66 @@ func InitRouter() {
 	api.HandleFunc("/deal_like", middleware.Use(handleDealLike, auth)).Methods(http.MethodPost, http.MethodDelete)
 
 	api.HandleFunc("/deal/{dealId}/images", getDealImageUrlsByDealId).Methods(http.MethodGet)
-	api.HandleFunc("/deal_image", middleware.Use(handleDealImage, auth)).Methods(http.MethodPost, http.MethodDelete)
+	api.HandleFunc("/deal_image", middleware.Use(handleDealImage, posting, auth)).Methods(http.MethodPost, http.MethodDelete)
 
 	api.HandleFunc("/deal/{dealId}/comments", getDealCommentsByDealId).Methods(http.MethodGet)
-	api.HandleFunc("/deal_comment", middleware.Use(handleDealComment, auth)).Methods(http.MethodPost, http.MethodPut, http.MethodDelete)
+	api.HandleFunc("/deal_comment", middleware.Use(handleDealComment, posting, auth, limit("comment"))).Methods(http.MethodPost, http.MethodPut, http.MethodDelete)
+	api.HandleFunc("/deal_comment/{commentId}/edits", getDealCommentEdits).Methods(http.MethodGet)
+	api.HandleFunc("/deal_comment/{commentId}/moderation", middleware.Use(moderateDealComment, auth)).Methods(http.MethodPost)
+	api.HandleFunc("/deal_comment/{commentId}/report", middleware.Use(reportDealComment, auth)).Methods(http.MethodPost)
+	api.HandleFunc("/deal_comment/{commentId}/reaction", middleware.Use(handleDealCommentReaction, auth)).Methods(http.MethodPost, http.MethodDelete)
+	api.HandleFunc("/deal_comment_reports", middleware.Use(getDealCommentReports, auth)).Methods(http.MethodGet)
 
 	api.HandleFunc("/deal_hidden", middleware.Use(hideDeal, auth)).Methods(http.MethodPost, http.MethodDelete)
+	api.HandleFunc("/deal_favorite", middleware.Use(handleDealFavorite, auth)).Methods(http.MethodPost, http.MethodDelete)
 
 	// Featured Banner Content
 	api.HandleFunc("/suggestions", getSuggestions).Methods(http.MethodGet)
 
 	// Chat notification
-	api.HandleFunc("/chat_notification", middleware.Use(pushNewChatNotification, auth)).Methods(http.MethodPost)
+	api.HandleFunc("/chat_notification", middleware.Use(pushNewChatNotification, posting, auth, limit("chatNotification"))).Methods(http.MethodPost)
 
 	// User
 	// TODO: Get another user's profile stats
-	api.HandleFunc("/user", updateUser).Methods(http.MethodPut)
+	api.HandleFunc("/user", middleware.Use(getSessionUser, auth)).Methods(http.MethodGet)
+	api.HandleFunc("/user", middleware.Use(updateUser, posting, auth)).Methods(http.MethodPut)
+	api.HandleFunc("/user/identities", middleware.Use(getUserIdentities, auth)).Methods(http.MethodGet)
+	api.HandleFunc("/user/identities", middleware.Use(linkUserIdentity, auth, limit("login"))).Methods(http.MethodPost)
+	api.HandleFunc("/user/identities/{provider}", middleware.Use(unlinkUserIdentity, auth, limit("login"))).Methods(http.MethodDelete)
+	api.HandleFunc("/user/totp", middleware.Use(startTOTPEnrollment, auth, limit("login"))).Methods(http.MethodPost)
+	api.HandleFunc("/user/totp", middleware.Use(disableTOTP, auth, limit("login"))).Methods(http.MethodDelete)
+	api.HandleFunc("/user/totp/confirm", middleware.Use(confirmTOTPEnrollment, auth, limit("login"))).Methods(http.MethodPost)
+	api.HandleFunc("/user/totp/recovery_codes", middleware.Use(regenerateRecoveryCodes, auth, limit("login"))).Methods(http.MethodPost)
 	api.HandleFunc("/user/{userId}", getUserById).Methods(http.MethodGet)
-	api.HandleFunc("/register/email", registerEmailUser).Methods(http.MethodPost)
-	api.HandleFunc("/register/social_media", registerBySocialMedia).Methods(http.MethodPost)
-	api.HandleFunc("/login/email", loginEmailUser).Methods(http.MethodPost)
-	api.HandleFunc("/login/facebook", loginFacebookUser).Methods(http.MethodPost)
-	api.HandleFunc("/login/google", loginGoogleUser).Methods(http.MethodPost)
 	api.HandleFunc("/logout", logoutUser).Methods(http.MethodPost)
+	api.HandleFunc("/sessions", middleware.Use(getUserSessions, auth)).Methods(http.MethodGet)
+	api.HandleFunc("/sessions", middleware.Use(revokeAllUserSessions, auth)).Methods(http.MethodDelete)
+	api.HandleFunc("/sessions/{sessionId}", middleware.Use(revokeUserSession, auth)).Methods(http.MethodDelete)
 
+	api.HandleFunc("/user_blocked", middleware.Use(getBlockedUsers, auth)).Methods(http.MethodGet)
 	api.HandleFunc("/user_blocked", middleware.Use(blockUser, auth)).Methods(http.MethodPost, http.MethodDelete)
 	api.HandleFunc("/user_reported", middleware.Use(reportUser, auth)).Methods(http.MethodPost)
 	api.HandleFunc("/user_banned", middleware.Use(isUserBanned, auth)).Methods(http.MethodPost)
 
+	// Admin, role middleware runs after auth
+	api.HandleFunc("/admin/reports", middleware.Use(getOpenReports, moderator, auth)).Methods(http.MethodGet)
+	api.HandleFunc("/admin/reports/{targetType}/{targetId}", middleware.Use(resolveReports, moderator, auth)).Methods(http.MethodPost)
+	api.HandleFunc("/admin/screenings", middleware.Use(getContentScreenings, moderator, auth)).Methods(http.MethodGet)
+	api.HandleFunc("/admin/screenings/{screeningId}", middleware.Use(reviewContentScreening, moderator, auth)).Methods(http.MethodPost)
+	api.HandleFunc("/admin/bans", middleware.Use(getBannedUsers, moderator, auth)).Methods(http.MethodGet)
+	api.HandleFunc("/admin/bans", middleware.Use(banUser, moderator, auth)).Methods(http.MethodPost)
+	api.HandleFunc("/admin/bans/{userId}", middleware.Use(unbanUser, moderator, auth)).Methods(http.MethodDelete)
+	api.HandleFunc("/admin/users/{userId}/role", middleware.Use(setUserRole, admin, auth)).Methods(http.MethodPut)
+	api.HandleFunc("/admin/users/{userId}/totp", middleware.Use(resetUserTOTP, admin, auth)).Methods(http.MethodDelete)
+	api.HandleFunc("/admin/categories", middleware.Use(createDealCategory, admin, auth)).Methods(http.MethodPost)
+	api.HandleFunc("/admin/categories/{categoryId}", middleware.Use(updateDealCategory, admin, auth)).Methods(http.MethodPut)
+	api.HandleFunc("/admin/suggestions", middleware.Use(getAllSuggestions, admin, auth)).Methods(http.MethodGet)
+	api.HandleFunc("/admin/suggestions", middleware.Use(createSuggestion, admin, auth)).Methods(http.MethodPost)
+	api.HandleFunc("/admin/suggestions/{suggestionId}", middleware.Use(updateSuggestion, admin, auth)).Methods(http.MethodPut)
+	api.HandleFunc("/admin/suggestions/{suggestionId}", middleware.Use(deleteSuggestion, admin, auth)).Methods(http.MethodDelete)
+	api.HandleFunc("/admin/deals/{dealId}/featured", middleware.Use(setDealFeatured, admin, auth)).Methods(http.MethodPut)
+	api.HandleFunc("/admin/audit_log", middleware.Use(getAuditLog, admin, auth)).Methods(http.MethodGet)
+
 	if appengine.IsAppEngine() {
 		http.Handle("/", router)
 		appengine.Main()
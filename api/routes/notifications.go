@@ -2,6 +2,7 @@ package routes
 
 import (
 	"context"
+	"database/sql"
 	"firebase.google.com/go/messaging"
 	"fmt"
 	"groupbuying.online/api/env"
@@ -22,14 +23,34 @@ func pushNewChatNotification(w http.ResponseWriter, r *http.Request) {
 	if !ok || senderUserId != userId {
 		log.Fatalf("error auth")
 	}
+	receiverFirId := result["receiverFirId"].(string)
 
+	// no notifications between users who blocked one another
+	var isBlocked bool
+	err = env.Db.QueryRow(`SELECT EXISTS (SELECT 1 FROM users r
+		WHERE r.fir_id = $1 AND NOT `+getNotBlockedFilter("r.id", "$2")+`)`,
+		receiverFirId, userId).Scan(&isBlocked)
+	if err != nil {
+		utils.WriteErrorJsonResponse(w, err.Error())
+		return
+	}
+	if isBlocked {
+		w.WriteHeader(http.StatusForbidden)
+		utils.WriteErrorJsonResponse(w, "blocked")
+		return
+	}
+
+	if env.Firebase == nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		utils.WriteErrorJsonResponse(w, "push notifications are disabled")
+		return
+	}
 	ctx := context.Background()
 	client, err := env.Firebase.Messaging(ctx)
 	if err != nil {
 		log.Fatalf("error getting Messaging client %s", err)
 	}
 	senderFirId := result["senderFirId"].(string)
-	receiverFirId := result["receiverFirId"].(string)
 	senderDisplayName := result["senderDisplayName"].(string)
 	messageText := result["messageText"].(string)
 
@@ -60,3 +81,80 @@ func pushNewChatNotification(w http.ResponseWriter, r *http.Request) {
 	}
 	utils.WriteSuccessJsonResponse(w, fmt.Sprint("Successfully sent message:", response))
 }
+
+// Members at which favorites are told a deal is nearly full, as a fraction of quantity
+const nearFullRatio = 0.8
+
+// Push a notification to everyone who favorited the deal except actorId, e.g. the poster editing it.
+// Failures are logged only, they should not fail the request that triggered them.
+func notifyDealFavorites(dealId string, actorId string, kind string, title string, body string) {
+	rows, err := env.Db.Query(`SELECT u.fir_id FROM deal_favorites d_f
+		INNER JOIN users u ON u.id = d_f.user_id
+		WHERE d_f.deal_id = $1 AND d_f.user_id <> $2 AND u.fir_id IS NOT NULL AND u.fir_id <> ''
+		AND `+getNotBlockedFilter("u.id", "$2"),
+		dealId, actorId)
+	if err != nil {
+		log.Printf("error getting favorites of deal '%s': %s", dealId, err)
+		return
+	}
+	defer utils.CloseRows(rows)
+	var firIds []string
+	for rows.Next() {
+		var firId string
+		if err := rows.Scan(&firId); err != nil {
+			log.Printf("error reading favorites of deal '%s': %s", dealId, err)
+			return
+		}
+		firIds = append(firIds, firId)
+	}
+	pushNotifications(firIds, map[string]string{
+		"dealId": dealId,
+		"kind": kind,
+	}, title, body)
+}
+
+// Push to each user's topic (their fir id), errors are logged only
+func pushNotifications(firIds []string, data map[string]string, title string, body string) {
+	if len(firIds) == 0 || env.Firebase == nil {
+		return
+	}
+	ctx := context.Background()
+	client, err := env.Firebase.Messaging(ctx)
+	if err != nil {
+		log.Printf("error getting Messaging client %s", err)
+		return
+	}
+	for _, firId := range firIds {
+		message := &messaging.Message{
+			Data: data,
+			Notification: &messaging.Notification{
+				Title: title,
+				Body: body,
+			},
+			Topic: firId,
+		}
+		if _, err := client.Send(ctx, message); err != nil {
+			log.Printf("error sending %s to '%s': %s", data["kind"], firId, err)
+		}
+	}
+}
+
+// Tell favorites once when a deal's members reach nearFullRatio of its quantity
+func notifyDealNearFull(dealId string, actorId string) {
+	var title string
+	var membersLeft int
+	err := env.Db.QueryRow(`UPDATE deals SET near_full_notified_at = timezone('utc', now())
+		WHERE id = $1 AND near_full_notified_at IS NULL AND inactive_at IS NULL
+		AND quantity > 0 AND members_count >= CEIL(quantity * $2::float)
+		RETURNING title, GREATEST(quantity - members_count, 0)`,
+		dealId, nearFullRatio).Scan(&title, &membersLeft)
+	if err == sql.ErrNoRows {
+		return
+	}
+	if err != nil {
+		log.Printf("error checking if deal '%s' is near full: %s", dealId, err)
+		return
+	}
+	notifyDealFavorites(dealId, actorId, "FavoriteDealNearFull",
+		fmt.Sprintf("%s is almost full", title), fmt.Sprintf("%d spots left", membersLeft))
+}
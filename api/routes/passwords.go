@@ -0,0 +1,253 @@
+package routes
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/asaskevich/govalidator"
+	"groupbuying.online/api/auth"
+	"groupbuying.online/api/env"
+	"groupbuying.online/api/mail"
+	"groupbuying.online/api/structs"
+	"groupbuying.online/api/utils"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Set in InitRouter, see package mail
+var mailer mail.Mailer
+
+// Mails with a one time token link, by token purpose
+var emailTokenMails = map[string]struct {
+	ttl     time.Duration
+	path    string
+	subject string
+	body    string
+}{
+	auth.VerifyEmailPurpose: {ttl: 48 * time.Hour, path: "/verify_email", subject: "Verify your email",
+		body: "Open this link to verify your email:\n\n%s\n\nIt expires in 48 hours."},
+	auth.ResetPasswordPurpose: {ttl: time.Hour, path: "/reset_password", subject: "Reset your password",
+		body: "Open this link to set a new password:\n\n%s\n\nIt expires in an hour, ignore this mail if you did not ask for it."},
+}
+
+// Mails a link with a new token of purpose to the user
+func sendEmailToken(userId string, email string, purpose string) error {
+	tokenMail := emailTokenMails[purpose]
+	token, err := auth.IssueEmailToken(env.Db, userId, purpose, tokenMail.ttl)
+	if err != nil {
+		return err
+	}
+	link := env.Conf.Mail.LinkBaseURL + tokenMail.path + "?token=" + url.QueryEscape(token)
+	return mailer.Send(mail.Message{To: email, Subject: tokenMail.subject, Body: fmt.Sprintf(tokenMail.body, link)})
+}
+
+// Mails the token link in the background, so that responses to unauthenticated requests
+// don't take longer for registered emails
+func sendEmailTokenInBackground(userId string, email string, purpose string) {
+	go func() {
+		if err := sendEmailToken(userId, email, purpose); err != nil {
+			log.Printf("error sending %s email to user '%s': %s", purpose, userId, err)
+		}
+	}()
+}
+
+func readEmailPassword(result utils.UnstructuredJSON) (email string, password string) {
+	email, _ = result["email"].(string)
+	password, _ = result["password"].(string)
+	return strings.ToLower(strings.TrimSpace(email)), password
+}
+
+// Register with an email and password, body: {"email", "password", "displayName", "countryCode"}.
+// Logging in needs the email to be verified with the link mailed to it.
+func registerPasswordUser(w http.ResponseWriter, r *http.Request) {
+	result, err := utils.ReadRequestToJson(r)
+	if err != nil {
+		utils.WriteErrorJsonResponse(w, err.Error())
+		return
+	}
+	email, password := readEmailPassword(result)
+	displayName, _ := result["displayName"].(string)
+	countryCode, _ := result["countryCode"].(string)
+	if !govalidator.IsEmail(email) {
+		err = fmt.Errorf("invalid email")
+	}
+	if err == nil {
+		err = auth.ValidatePassword(password)
+	}
+	if err == nil {
+		err = utils.IsValidUsername(displayName)
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		utils.WriteErrorJsonResponse(w, err.Error())
+		return
+	}
+	hash, err := auth.HashPassword(password)
+	if err != nil {
+		utils.WriteErrorJsonResponse(w, err.Error())
+		return
+	}
+
+	authType := "password"
+	var userId string
+	err = checkDisplayNameFree(env.Db, displayName, "")
+	if err == nil {
+		err = env.Db.QueryRow(`INSERT INTO users (email, display_name, auth_type, password_hash, country_code)
+			VALUES ($1, $2, $3, $4, NULLIF($5, '')) ON CONFLICT DO NOTHING RETURNING id`,
+			email, displayName, authType, hash, countryCode).Scan(&userId)
+	}
+	if err == sql.ErrNoRows {
+		err = errAlreadyRegistered
+	}
+	if !writeRegisterError(w, err) {
+		return
+	}
+	recordAudit(r, auditEntry{actorId: userId, action: "user.register", targetType: "user", targetId: userId,
+		details: map[string]interface{}{"authType": authType}})
+	if err = sendEmailToken(userId, email, auth.VerifyEmailPurpose); err != nil {
+		log.Printf("error sending verification email to user '%s': %s", userId, err)
+	}
+
+	user := structs.User{
+		ID:          userId,
+		DisplayName: displayName,
+		CountryCode: countryCode,
+		AuthType:    &authType,
+		Email:       &email,
+	}
+	respondUser(user, w)
+}
+
+// Log in with an email and password, body: {"email", "password"}
+func loginPasswordUser(w http.ResponseWriter, r *http.Request) {
+	result, err := utils.ReadRequestToJson(r)
+	if err != nil {
+		utils.WriteErrorJsonResponse(w, err.Error())
+		return
+	}
+	email, password := readEmailPassword(result)
+	var userId string
+	var hash sql.NullString
+	var isVerified bool
+	err = env.Db.QueryRow(`SELECT id, password_hash, email_verified_at NOTNULL FROM users WHERE email = $1`,
+		email).Scan(&userId, &hash, &isVerified)
+	if err != nil && err != sql.ErrNoRows {
+		utils.WriteErrorJsonResponse(w, err.Error())
+		return
+	}
+	if err = auth.CheckPassword(hash.String, password); err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		utils.WriteErrorJsonResponse(w, err.Error())
+		return
+	}
+	if !isVerified {
+		w.WriteHeader(http.StatusForbidden)
+		utils.WriteErrorJsonResponse(w, "email not verified, open the link mailed to it")
+		return
+	}
+	user, err := getAuthUserById(userId)
+	if err != nil {
+		utils.WriteErrorJsonResponse(w, err.Error())
+		return
+	}
+	loginUser(&user, w, r)
+}
+
+// Verify the email with the token of the mailed link, body: {"token": "..."}
+func verifyEmail(w http.ResponseWriter, r *http.Request) {
+	result, err := utils.ReadRequestToJson(r)
+	if err != nil {
+		utils.WriteErrorJsonResponse(w, err.Error())
+		return
+	}
+	token, _ := result["token"].(string)
+	userId, err := auth.ConsumeEmailToken(env.Db, token, auth.VerifyEmailPurpose)
+	if err == nil {
+		_, err = env.Db.Exec(`UPDATE users SET email_verified_at = COALESCE(email_verified_at, timezone('utc', now()))
+			WHERE id = $1`, userId)
+	}
+	if err != nil {
+		utils.WriteErrorJsonResponse(w, err.Error())
+		return
+	}
+	recordAudit(r, auditEntry{actorId: userId, action: "user.verify_email", targetType: "user", targetId: userId})
+	utils.WriteSuccessJsonResponse(w, "")
+}
+
+// Mail a new verification link, body: {"email": "..."}.
+// Responds the same whether or not the email has an unverified password account.
+func resendVerificationEmail(w http.ResponseWriter, r *http.Request) {
+	result, err := utils.ReadRequestToJson(r)
+	if err != nil {
+		utils.WriteErrorJsonResponse(w, err.Error())
+		return
+	}
+	email, _ := readEmailPassword(result)
+	var userId string
+	err = env.Db.QueryRow(`SELECT id FROM users
+		WHERE email = $1 AND password_hash NOTNULL AND email_verified_at ISNULL`, email).Scan(&userId)
+	if err == nil {
+		sendEmailTokenInBackground(userId, email, auth.VerifyEmailPurpose)
+	} else if err != sql.ErrNoRows {
+		log.Printf("error finding user to resend verification email: %s", err)
+	}
+	utils.WriteSuccessJsonResponse(w, "")
+}
+
+// Mail a password reset link, body: {"email": "..."}.
+// Responds the same whether or not the email is registered.
+func requestPasswordReset(w http.ResponseWriter, r *http.Request) {
+	result, err := utils.ReadRequestToJson(r)
+	if err != nil {
+		utils.WriteErrorJsonResponse(w, err.Error())
+		return
+	}
+	email, _ := readEmailPassword(result)
+	var userId string
+	err = env.Db.QueryRow(`SELECT id FROM users WHERE email = $1`, email).Scan(&userId)
+	if err == nil {
+		sendEmailTokenInBackground(userId, email, auth.ResetPasswordPurpose)
+	} else if err != sql.ErrNoRows {
+		log.Printf("error finding user to send password reset email: %s", err)
+	}
+	utils.WriteSuccessJsonResponse(w, "")
+}
+
+// Set a new password with the token of the mailed link, body: {"token": "...", "password": "..."}.
+// This also verifies the email, and logs out every session as the old password may be known to someone else.
+func resetPassword(w http.ResponseWriter, r *http.Request) {
+	result, err := utils.ReadRequestToJson(r)
+	if err != nil {
+		utils.WriteErrorJsonResponse(w, err.Error())
+		return
+	}
+	token, _ := result["token"].(string)
+	password, _ := result["password"].(string)
+	if err = auth.ValidatePassword(password); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		utils.WriteErrorJsonResponse(w, err.Error())
+		return
+	}
+	hash, err := auth.HashPassword(password)
+	if err != nil {
+		utils.WriteErrorJsonResponse(w, err.Error())
+		return
+	}
+	userId, err := auth.ConsumeEmailToken(env.Db, token, auth.ResetPasswordPurpose)
+	if err == nil {
+		_, err = env.Db.Exec(`UPDATE users SET password_hash = $2,
+			email_verified_at = COALESCE(email_verified_at, timezone('utc', now()))
+			WHERE id = $1`, userId, hash)
+	}
+	if err == nil {
+		_, err = logoutEverywhere(userId)
+	}
+	if err != nil {
+		utils.WriteErrorJsonResponse(w, err.Error())
+		return
+	}
+	recordAudit(r, auditEntry{actorId: userId, action: "user.password_reset", targetType: "user", targetId: userId})
+	utils.WriteSuccessJsonResponse(w, "")
+}
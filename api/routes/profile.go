@@ -0,0 +1,172 @@
+package routes
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/asaskevich/govalidator"
+	"github.com/lib/pq"
+	"groupbuying.online/api/env"
+	"groupbuying.online/api/utils"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	maxBioLength      = 280
+	maxImageURLLength = 256
+)
+
+var errDisplayNameTaken = errors.New("display name taken")
+
+// Time after which the display name can be changed again, see displayNameCooldownDays in config
+type displayNameCooldownError time.Time
+
+func (e displayNameCooldownError) Error() string {
+	return fmt.Sprintf("display name can be changed again after %s", time.Time(e).Format(time.RFC3339))
+}
+
+// Profile fields of PUT /api/user, each is optional
+var profileCols = map[string]string{
+	"displayName": "string",
+	"countryCode": "string",
+	"imageUrl":    "string",
+	"bio":         "string",
+}
+
+// errDisplayNameTaken if a user other than exceptUserId has the display name, ignoring case
+func checkDisplayNameFree(db sqlRunner, displayName string, exceptUserId string) error {
+	var taken bool
+	err := db.QueryRow(`SELECT EXISTS (SELECT 1 FROM users
+		WHERE lower(display_name) = lower($1) AND id::text <> $2)`, displayName, exceptUserId).Scan(&taken)
+	if err == nil && taken {
+		err = errDisplayNameTaken
+	}
+	return err
+}
+
+// Validated column values of a profile update, empty strings clear the columns other than display_name
+func readProfileValues(result utils.UnstructuredJSON) (map[string]interface{}, error) {
+	colValues, err := readColValues(result, profileCols)
+	if err != nil {
+		return nil, err
+	}
+	for col, value := range colValues {
+		str, _ := value.(string)
+		str = strings.TrimSpace(str)
+		if str == "" && col != "display_name" {
+			colValues[col] = nil
+			continue
+		}
+		switch col {
+		case "display_name":
+			if str == "" {
+				err = errors.New("display name can't be empty")
+			} else {
+				err = utils.IsValidUsername(str)
+			}
+		case "country_code":
+			str = strings.ToUpper(str)
+			if !govalidator.IsISO3166Alpha2(str) {
+				err = errors.New("invalid country code")
+			}
+		case "image_url":
+			if len(str) > maxImageURLLength || !govalidator.IsURL(str) {
+				err = errors.New("invalid image url")
+			}
+		case "bio":
+			if utf8.RuneCountInString(str) > maxBioLength {
+				err = fmt.Errorf("bio more than %d characters", maxBioLength)
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		colValues[col] = str
+	}
+	return colValues, nil
+}
+
+// Updates the profile columns of userId. Changing the display name, other than its case,
+// needs it to be free and the cooldown since the last change to be over.
+func updateProfile(tx *sql.Tx, userId string, colValues map[string]interface{}) (string, error) {
+	var current string
+	var changedAt *time.Time
+	err := tx.QueryRow(`SELECT display_name, display_name_changed_at FROM users WHERE id = $1 FOR UPDATE`,
+		userId).Scan(&current, &changedAt)
+	if err != nil {
+		return "", err
+	}
+	if displayName, ok := colValues["display_name"].(string); ok && displayName == current {
+		delete(colValues, "display_name")
+	} else if ok && !strings.EqualFold(displayName, current) {
+		cooldown := time.Duration(env.Conf.DisplayNameCooldownDays) * 24 * time.Hour
+		if changedAt != nil && time.Now().UTC().Before(changedAt.Add(cooldown)) {
+			return "", displayNameCooldownError(changedAt.Add(cooldown))
+		}
+		if err = checkDisplayNameFree(tx, displayName, userId); err != nil {
+			return "", err
+		}
+		colValues["display_name_changed_at"] = time.Now().UTC()
+	}
+	if len(colValues) == 0 {
+		return userId, nil
+	}
+	userId, err = updateColValues(tx, "users", userId, colValues)
+	if pqErr, ok := err.(*pq.Error); ok && pqErr.Constraint == "users_unique_lower_display_name_idx" {
+		// taken by a concurrent change
+		err = errDisplayNameTaken
+	}
+	return userId, err
+}
+
+// Profile of the session user, with their sign in details
+func getSessionUser(w http.ResponseWriter, r *http.Request) {
+	userId, _ := utils.GetUserIdInSession(r)
+	user, err := getAuthUserById(userId)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		utils.WriteErrorJsonResponse(w, err.Error())
+		return
+	}
+	respondUser(user, w)
+}
+
+// Partially updates the session user's profile, body: any of {"displayName", "countryCode", "imageUrl", "bio"}.
+// Responds the updated user.
+func updateUser(w http.ResponseWriter, r *http.Request) {
+	userId, _ := utils.GetUserIdInSession(r)
+	result, err := utils.ReadRequestToJson(r)
+	if err != nil {
+		utils.WriteErrorJsonResponse(w, err.Error())
+		return
+	}
+	// older clients send their own id, the target is always the session user
+	delete(result, "userId")
+	colValues, err := readProfileValues(result)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		utils.WriteErrorJsonResponse(w, err.Error())
+		return
+	}
+	_, err = runAuditedAction(r, "user.update_profile", "user", userId, result, func(tx *sql.Tx) (string, error) {
+		return updateProfile(tx, userId, colValues)
+	})
+	if _, ok := err.(displayNameCooldownError); ok {
+		w.WriteHeader(http.StatusTooManyRequests)
+		utils.WriteErrorJsonResponse(w, err.Error())
+		return
+	}
+	if err == errDisplayNameTaken {
+		w.WriteHeader(http.StatusConflict)
+		utils.WriteErrorJsonResponse(w, err.Error())
+		return
+	}
+	if err != nil {
+		utils.WriteErrorJsonResponse(w, err.Error())
+		return
+	}
+	getSessionUser(w, r)
+}
@@ -0,0 +1,67 @@
+package routes
+
+import (
+	"groupbuying.online/api/env"
+	"groupbuying.online/api/utils"
+	"net/http"
+)
+
+// Hot score = engagement / (age in hours + 2) ^ gravity
+// where engagement = net votes + weighted recent joins + weighted recent comments.
+// Bigger gravity makes older deals sink faster.
+const (
+	hotScoreGravity       = 1.5
+	hotScoreJoinWeight    = 2.0
+	hotScoreCommentWeight = 1.0
+	hotScoreRecentWindow  = "48 hours"
+)
+
+// Recomputes deals.hot_score for active deals, called periodically by cron
+// so that sorting by `hot` in getDeals only reads the stored column.
+func updateDealHotScores(w http.ResponseWriter, r *http.Request) {
+	res, err := env.Db.Exec(`UPDATE deals d
+		SET hot_score = s.score, hot_score_at = timezone('utc', now())
+		FROM (
+			SELECT d.id,
+				(d.likes_count - d.downvotes_count + $1 * COALESCE(d_m.recent_joins, 0) + $2 * COALESCE(d_c.recent_comments, 0))
+				/ power(EXTRACT(EPOCH FROM (timezone('utc', now()) - d.posted_at)) / 3600 + 2, $3) AS score
+			FROM deals d
+			LEFT JOIN (
+				SELECT m.deal_id, COUNT(*) AS recent_joins
+				FROM deal_memberships m INNER JOIN deals md ON md.id = m.deal_id
+				WHERE m.user_id <> md.poster_id AND m.joined_at > timezone('utc', now()) - $4::interval
+				GROUP BY m.deal_id
+			) d_m ON d_m.deal_id = d.id
+			LEFT JOIN (
+				SELECT deal_id, COUNT(*) AS recent_comments
+				FROM deal_comments
+				WHERE removed_at IS NULL AND posted_at > timezone('utc', now()) - $4::interval
+				GROUP BY deal_id
+			) d_c ON d_c.deal_id = d.id
+			WHERE d.inactive_at IS NULL
+		) s
+		WHERE d.id = s.id`,
+		hotScoreJoinWeight, hotScoreCommentWeight, hotScoreGravity, hotScoreRecentWindow)
+	if err != nil {
+		utils.WriteError(w, err.Error())
+		return
+	}
+	updated, err := res.RowsAffected()
+	if err != nil {
+		utils.WriteError(w, err.Error())
+		return
+	}
+	utils.WriteJsonResponse(w, "updated", updated)
+}
+
+// Recomputes the engagement counters on deals from their source tables,
+// in case the triggers in sql/common/3_deal_counters.sql were bypassed.
+func repairDealCounters(w http.ResponseWriter, r *http.Request) {
+	var repaired int
+	err := env.Db.QueryRow(`SELECT repair_deal_counters()`).Scan(&repaired)
+	if err != nil {
+		utils.WriteError(w, err.Error())
+		return
+	}
+	utils.WriteJsonResponse(w, "repaired", repaired)
+}
@@ -0,0 +1,208 @@
+package routes
+
+import (
+	"groupbuying.online/api/env"
+	"groupbuying.online/api/utils"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Candidate score = category affinity + proximity to the user's usual deals + popularity.
+// Affinity is the share of the user's joins (and half weighted upvotes) in the deal's category,
+// proximity is 1 at the centroid of joined deals and halves at recommendationProximityKm.
+const (
+	recommendationLookback        = "90 days"
+	recommendationCandidateWindow = "30 days"
+	recommendationsPerUser        = 100
+	recommendationCategoryWeight  = 3.0
+	recommendationProximityWeight = 2.0
+	recommendationProximityKm     = 5.0
+	recommendationHotWeight       = 1.0
+)
+
+// Similar deal score = weighted trigram similarity of title and description
+// + same category + proximity + closeness in price (1 at equal price, 0 at e times apart).
+const (
+	similarTitleWeight       = 2.0
+	similarDescriptionWeight = 1.0
+	similarCategoryWeight    = 1.0
+	similarProximityWeight   = 1.0
+	similarPriceWeight       = 0.5
+)
+
+// Rebuilds deal_recommendations for users active within the lookback, called periodically by cron.
+func updateDealRecommendations(w http.ResponseWriter, r *http.Request) {
+	tx, err := env.Db.Begin()
+	if err != nil {
+		utils.WriteError(w, err.Error())
+		return
+	}
+	if _, err = tx.Exec(`DELETE FROM deal_recommendations`); err != nil {
+		_ = tx.Rollback()
+		utils.WriteError(w, err.Error())
+		return
+	}
+	res, err := tx.Exec(`INSERT INTO deal_recommendations (user_id, deal_id, score)
+		WITH engagements AS (
+			SELECT user_id, deal_id, 1.0 AS weight FROM deal_memberships
+			WHERE joined_at > timezone('utc', now()) - $1::interval
+			UNION ALL
+			SELECT user_id, deal_id, 0.5 AS weight FROM deal_likes
+			WHERE is_upvote AND posted_at > timezone('utc', now()) - $1::interval
+		), category_affinity AS (
+			SELECT e.user_id, d.category_id,
+				SUM(e.weight) / SUM(SUM(e.weight)) OVER (PARTITION BY e.user_id) AS affinity
+			FROM engagements e INNER JOIN deals d ON d.id = e.deal_id
+			GROUP BY e.user_id, d.category_id
+		), home AS (
+			SELECT m.user_id, ST_Centroid(ST_Collect(d.point::geometry))::geography AS point
+			FROM deal_memberships m INNER JOIN deals d ON d.id = m.deal_id
+			WHERE d.point IS NOT NULL AND m.joined_at > timezone('utc', now()) - $1::interval
+			GROUP BY m.user_id
+		), scored AS (
+			SELECT u.user_id, d.id AS deal_id,
+				$4::float * COALESCE(c_a.affinity, 0)
+				+ $5::float * COALESCE(1 / (1 + ST_Distance(h.point, d.point) / 1000 / $6::float), 0)
+				+ $7::float * ln(1 + GREATEST(d.hot_score, 0)) AS score
+			FROM (SELECT DISTINCT user_id FROM engagements) u
+			CROSS JOIN deals d
+			LEFT JOIN category_affinity c_a ON c_a.user_id = u.user_id AND c_a.category_id = d.category_id
+			LEFT JOIN home h ON h.user_id = u.user_id
+			WHERE d.inactive_at IS NULL AND d.moderated_at IS NULL AND d.held_at IS NULL
+				AND d.posted_at > timezone('utc', now()) - $2::interval
+				AND d.poster_id <> u.user_id
+				AND NOT is_user_sanctioned(d.poster_id, 'shadow')
+				AND NOT EXISTS (SELECT 1 FROM deal_memberships m WHERE m.deal_id = d.id AND m.user_id = u.user_id)
+				AND NOT EXISTS (SELECT 1 FROM deal_hidden d_h WHERE d_h.deal_id = d.id AND d_h.user_id = u.user_id)
+				AND NOT EXISTS (SELECT 1 FROM users_blocked u_b
+					WHERE (u_b.user_id = u.user_id AND u_b.blocked_id = d.poster_id)
+					OR (u_b.user_id = d.poster_id AND u_b.blocked_id = u.user_id))
+		)
+		SELECT user_id, deal_id, score FROM (
+			SELECT user_id, deal_id, score,
+				ROW_NUMBER() OVER (PARTITION BY user_id ORDER BY score DESC) AS deal_rank
+			FROM scored
+		) ranked
+		WHERE deal_rank <= $3`,
+		recommendationLookback, recommendationCandidateWindow, recommendationsPerUser,
+		recommendationCategoryWeight, recommendationProximityWeight, recommendationProximityKm,
+		recommendationHotWeight)
+	if err != nil {
+		_ = tx.Rollback()
+		utils.WriteError(w, err.Error())
+		return
+	}
+	if err = tx.Commit(); err != nil {
+		utils.WriteError(w, err.Error())
+		return
+	}
+	inserted, _ := res.RowsAffected()
+	utils.WriteJsonResponse(w, "recommendations", inserted)
+}
+
+// Home feed for the session user, falls back to hot deals until recommendations are computed.
+func getDealsForYou(w http.ResponseWriter, r *http.Request) {
+	userId, ok := utils.GetUserIdInSession(r)
+	if !ok {
+		utils.WriteErrorJsonResponse(w, "invalid user")
+		return
+	}
+	values := r.URL.Query()
+	pageSize := 30
+	if pageSizeNum, err := strconv.Atoi(values.Get("pageSize")); err == nil && pageSizeNum > 0 {
+		pageSize = pageSizeNum
+	}
+	offset := 0
+	if offsetNum, err := strconv.Atoi(values.Get("offset")); err == nil && offsetNum > 0 {
+		offset = offsetNum
+	}
+
+	// recommendations can be stale, so apply the same filters as listings again
+	filterStrings := append([]string{
+		"d.inactive_at IS NULL",
+		"d.poster_id <> $1",
+		"NOT EXISTS (SELECT 1 FROM deal_memberships d_m WHERE d_m.deal_id=d.id AND d_m.user_id=$1)",
+	}, getDealViewerFilters("$1::uuid")...)
+	filterStr := " WHERE " + strings.Join(filterStrings, " AND ")
+
+	query := getDealSelectCols("$1::uuid") + dealFromTables +
+		" INNER JOIN deal_recommendations d_r ON d_r.deal_id=d.id AND d_r.user_id=$1" +
+		filterStr + " ORDER BY d_r.score DESC LIMIT $2 OFFSET $3"
+	rows, err := env.Db.Query(query, userId, pageSize, offset)
+	if err != nil {
+		utils.WriteErrorJsonResponse(w, err.Error())
+		return
+	}
+	defer utils.CloseRows(rows)
+	deals, err := scanDealRows(rows)
+	if err != nil {
+		utils.WriteErrorJsonResponse(w, err.Error())
+		return
+	}
+
+	if len(deals) == 0 && offset == 0 {
+		query = getDealSelectCols("$1::uuid") + dealFromTables + filterStr +
+			" ORDER BY d.hot_score DESC LIMIT $2"
+		fallbackRows, err := env.Db.Query(query, userId, pageSize)
+		if err != nil {
+			utils.WriteErrorJsonResponse(w, err.Error())
+			return
+		}
+		defer utils.CloseRows(fallbackRows)
+		deals, err = scanDealRows(fallbackRows)
+		if err != nil {
+			utils.WriteErrorJsonResponse(w, err.Error())
+			return
+		}
+	}
+	writeDeals(w, deals)
+}
+
+// "More like this" for a deal, ranks other active deals by text, category, location and price.
+func getSimilarDeals(w http.ResponseWriter, r *http.Request) {
+	dealId, err := getURLParamUUID("dealId", r)
+	if err != nil {
+		utils.WriteErrorJsonResponse(w, err.Error())
+		return
+	}
+	pageSize := 10
+	if pageSizeNum, err := strconv.Atoi(r.URL.Query().Get("pageSize")); err == nil && pageSizeNum > 0 {
+		pageSize = pageSizeNum
+	}
+	var viewerId interface{}
+	if reqUserId, ok := utils.GetUserIdInSession(r); ok {
+		viewerId = reqUserId
+	}
+
+	filterStrings := append([]string{
+		"d.id <> s.id",
+		"d.inactive_at IS NULL",
+		// candidates share the category or are close enough in title for the trigram index
+		"(d.category_id = s.category_id OR d.title % s.title)",
+	}, getDealViewerFilters("$9::uuid")...)
+	scoreStr := `$3::float * similarity(d.title, s.title)
+		+ $4::float * similarity(d.description, s.description)
+		+ $5::float * (CASE WHEN d.category_id = s.category_id THEN 1 ELSE 0 END)
+		+ $6::float * COALESCE(1 / (1 + ST_Distance(d.point, s.point) / 1000 / $7::float), 0)
+		+ $8::float * (CASE WHEN d.total_price > 0 AND s.total_price > 0
+			THEN GREATEST(0, 1 - ABS(LN(d.total_price / s.total_price))) ELSE 0 END)`
+	query := getDealSelectCols("$9::uuid") + dealFromTables +
+		" INNER JOIN deals s ON s.id=$1" +
+		" WHERE " + strings.Join(filterStrings, " AND ") +
+		" ORDER BY " + scoreStr + " DESC LIMIT $2"
+	rows, err := env.Db.Query(query, dealId, pageSize,
+		similarTitleWeight, similarDescriptionWeight, similarCategoryWeight,
+		similarProximityWeight, recommendationProximityKm, similarPriceWeight, viewerId)
+	if err != nil {
+		utils.WriteErrorJsonResponse(w, err.Error())
+		return
+	}
+	defer utils.CloseRows(rows)
+	deals, err := scanDealRows(rows)
+	if err != nil {
+		utils.WriteErrorJsonResponse(w, err.Error())
+		return
+	}
+	writeDeals(w, deals)
+}
@@ -0,0 +1,241 @@
+package routes
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+	"groupbuying.online/api/env"
+	"groupbuying.online/api/structs"
+	"groupbuying.online/api/utils"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+// Reportable content: table of the target, the column of its author
+// and the condition for it to still be reportable
+var reportTargets = map[string]struct {
+	table     string
+	authorCol string
+	liveCond  string
+}{
+	"deal":    {table: "deals", authorCol: "poster_id", liveCond: "true"},
+	"comment": {table: "deal_comments", authorCol: "user_id", liveCond: "t.removed_at ISNULL"},
+	"user":    {table: "users", authorCol: "id", liveCond: "true"},
+}
+
+// Keep in sync with content_reports.reason_code in sql/common/1_deals.sql
+var reportReasonCodes = map[string]bool{
+	"spam":       true,
+	"scam":       true,
+	"offensive":  true,
+	"harassment": true,
+	"prohibited": true,
+	"other":      true,
+}
+
+// Admin actions on reported content and the resolution they record on its reports
+var reportResolutions = map[string]string{
+	"dismiss": "dismissed",
+	"hide":    "hidden",
+	"ban":     "banned",
+}
+
+const maxReportReasonLength = 256
+
+// Records a report by reporterId, each user has one open report of a target at a time.
+// Deals and comments are hidden once reportAutoHideThreshold distinct users have open reports on them.
+func createContentReport(r *http.Request, reporterId string, targetType string, targetId string,
+	reasonCode string, reason string) (reportId string, err error) {
+	target, ok := reportTargets[targetType]
+	if !ok {
+		return "", fmt.Errorf("invalid target type '%s'", targetType)
+	}
+	if !reportReasonCodes[reasonCode] {
+		return "", fmt.Errorf("invalid reason code '%s'", reasonCode)
+	}
+	if len(reason) > maxReportReasonLength {
+		return "", fmt.Errorf("reason more than %d characters", maxReportReasonLength)
+	}
+	err = env.Db.QueryRow(`INSERT INTO content_reports (reporter_id, target_type, target_id, reason_code, reason)
+		SELECT $1::uuid, $2::text, t.id, $4::text, $5::text FROM `+target.table+` t WHERE t.id = $3 AND `+target.liveCond+`
+		ON CONFLICT (reporter_id, target_type, target_id) WHERE resolved_at IS NULL DO NOTHING
+		RETURNING id`, reporterId, targetType, targetId, reasonCode, reason).Scan(&reportId)
+	if err == sql.ErrNoRows {
+		return "", fmt.Errorf("%s not found or already reported", targetType)
+	}
+	if err != nil {
+		return "", err
+	}
+	recordAudit(r, auditEntry{actorId: reporterId, action: "report.create", targetType: targetType, targetId: targetId,
+		details: map[string]interface{}{"reportId": reportId, "reasonCode": reasonCode}})
+
+	threshold := env.Conf.ReportAutoHideThreshold
+	if threshold > 0 && targetType != "user" {
+		res, err := env.Db.Exec(`UPDATE `+target.table+` SET moderated_at = timezone('utc', now())
+			WHERE id = $1 AND moderated_at ISNULL
+			AND (SELECT COUNT(DISTINCT reporter_id) FROM content_reports
+				WHERE target_type = $2 AND target_id = $1 AND resolved_at ISNULL) >= $3`,
+			targetId, targetType, threshold)
+		if err != nil {
+			log.Printf("error auto hiding %s '%s': %s", targetType, targetId, err)
+		} else if hidden, _ := res.RowsAffected(); hidden > 0 {
+			log.Printf("auto hid %s '%s' after %d reports", targetType, targetId, threshold)
+			recordAudit(r, auditEntry{action: "report.autohide", targetType: targetType, targetId: targetId,
+				details: map[string]interface{}{"threshold": threshold}})
+		}
+	}
+	return reportId, nil
+}
+
+// Report a deal, body: {"reasonCode": "spam", "reason": "optional details"}
+func reportDeal(w http.ResponseWriter, r *http.Request) {
+	dealId, err := getURLParamUUID("dealId", r)
+	if err != nil {
+		utils.WriteErrorJsonResponse(w, err.Error())
+		return
+	}
+	writeContentReport(w, r, "deal", dealId)
+}
+
+func writeContentReport(w http.ResponseWriter, r *http.Request, targetType string, targetId string) {
+	result, err := utils.ReadRequestToJson(r)
+	if err != nil {
+		utils.WriteErrorJsonResponse(w, err.Error())
+		return
+	}
+	reasonCode, _ := result["reasonCode"].(string)
+	reason, _ := result["reason"].(string)
+	userId, ok := utils.GetUserIdInSession(r)
+	if !ok {
+		utils.WriteErrorJsonResponse(w, "invalid input")
+		return
+	}
+	reportId, err := createContentReport(r, userId, targetType, targetId, reasonCode, reason)
+	if err != nil {
+		utils.WriteErrorJsonResponse(w, err.Error())
+		return
+	}
+	utils.WriteSuccessJsonResponse(w, reportId)
+}
+
+// Moderation queue: open reports grouped by target, most distinct reporters first.
+// Filter with `targetType`, page with `pageSize` and `offset`.
+func getOpenReports(w http.ResponseWriter, r *http.Request) {
+	values := r.URL.Query()
+	var targetType interface{}
+	if targetTypeStr := values.Get("targetType"); targetTypeStr != "" {
+		if _, ok := reportTargets[targetTypeStr]; !ok {
+			utils.WriteErrorJsonResponse(w, "invalid target type")
+			return
+		}
+		targetType = targetTypeStr
+	}
+	pageSize := 30
+	if pageSizeNum, err := strconv.Atoi(values.Get("pageSize")); err == nil && pageSizeNum > 0 {
+		pageSize = pageSizeNum
+	}
+	offset := 0
+	if offsetNum, err := strconv.Atoi(values.Get("offset")); err == nil && offsetNum > 0 {
+		offset = offsetNum
+	}
+	rows, err := env.Db.Query(`SELECT c_r.target_type, c_r.target_id,
+		COUNT(*), COUNT(DISTINCT c_r.reporter_id),
+		array_agg(DISTINCT c_r.reason_code),
+		array_remove(array_agg(NULLIF(c_r.reason, '')), NULL),
+		MIN(c_r.created_at), MAX(c_r.created_at),
+		CASE c_r.target_type
+			WHEN 'deal' THEN (SELECT moderated_at NOTNULL FROM deals WHERE id = c_r.target_id)
+			WHEN 'comment' THEN (SELECT moderated_at NOTNULL FROM deal_comments WHERE id = c_r.target_id)
+			ELSE false
+		END
+		FROM content_reports c_r
+		WHERE c_r.resolved_at ISNULL AND ($1::text ISNULL OR c_r.target_type = $1)
+		GROUP BY c_r.target_type, c_r.target_id
+		ORDER BY COUNT(DISTINCT c_r.reporter_id) DESC, MIN(c_r.created_at)
+		LIMIT $2 OFFSET $3`, targetType, pageSize, offset)
+	if err != nil {
+		utils.WriteErrorJsonResponse(w, err.Error())
+		return
+	}
+	defer utils.CloseRows(rows)
+	targets := []structs.ReportedTarget{}
+	for rows.Next() {
+		var target structs.ReportedTarget
+		var isHidden sql.NullBool
+		err = rows.Scan(&target.TargetType, &target.TargetID, &target.Reports, &target.Reporters,
+			pq.Array(&target.ReasonCodes), pq.Array(&target.Reasons),
+			&target.FirstReportedAt, &target.LastReportedAt, &isHidden)
+		if err != nil {
+			utils.WriteErrorJsonResponse(w, err.Error())
+			return
+		}
+		target.IsHidden = isHidden.Bool
+		targets = append(targets, target)
+	}
+	utils.WriteStructs(w, targets)
+}
+
+// Resolve all open reports of a target, body: {"action": "dismiss" | "hide" | "ban", "note": "..."}.
+// Dismissing restores auto hidden content, banning also hides the reported content.
+func resolveReports(w http.ResponseWriter, r *http.Request) {
+	targetType := mux.Vars(r)["targetType"]
+	target, ok := reportTargets[targetType]
+	targetId, err := getURLParamUUID("targetId", r)
+	if !ok || err != nil {
+		utils.WriteErrorJsonResponse(w, "invalid target")
+		return
+	}
+	result, err := utils.ReadRequestToJson(r)
+	if err != nil {
+		utils.WriteErrorJsonResponse(w, err.Error())
+		return
+	}
+	action, _ := result["action"].(string)
+	note, _ := result["note"].(string)
+	resolution, ok := reportResolutions[action]
+	if !ok || (action == "hide" && targetType == "user") || len(note) > maxReportReasonLength {
+		utils.WriteErrorJsonResponse(w, fmt.Sprintf("invalid action '%s'", action))
+		return
+	}
+	adminId, _ := utils.GetUserIdInSession(r)
+
+	var resolved int64
+	details := map[string]interface{}{"note": note}
+	_, err = runAuditedAction(r, "reports."+action, targetType, targetId, details, func(tx *sql.Tx) (string, error) {
+		var authorId string
+		err := tx.QueryRow(`SELECT `+target.authorCol+` FROM `+target.table+` WHERE id = $1`,
+			targetId).Scan(&authorId)
+		if err == nil && targetType != "user" {
+			moderatedAt := "timezone('utc', now())"
+			if action == "dismiss" {
+				moderatedAt = "NULL"
+			}
+			_, err = tx.Exec(`UPDATE `+target.table+` SET moderated_at = `+moderatedAt+` WHERE id = $1`, targetId)
+		}
+		if err == nil && action == "ban" {
+			_, err = insertUserBan(tx, authorId, "ban", note, nil, adminId)
+			if err == sql.ErrNoRows {
+				err = fmt.Errorf("author can not be banned")
+			}
+		}
+		if err == nil {
+			var res sql.Result
+			res, err = tx.Exec(`UPDATE content_reports
+				SET resolved_at = timezone('utc', now()), resolved_by = $3, resolution = $4, resolution_note = $5
+				WHERE target_type = $1 AND target_id = $2 AND resolved_at ISNULL`,
+				targetType, targetId, adminId, resolution, note)
+			if err == nil {
+				resolved, err = res.RowsAffected()
+				details["resolved"] = resolved
+			}
+		}
+		return targetId, err
+	})
+	if err != nil {
+		utils.WriteErrorJsonResponse(w, err.Error())
+		return
+	}
+	utils.WriteJsonResponse(w, "resolved", resolved)
+}
@@ -0,0 +1,140 @@
+package routes
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/lib/pq"
+	"groupbuying.online/api/env"
+	"groupbuying.online/api/screening"
+	"groupbuying.online/api/structs"
+	"groupbuying.online/api/utils"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Screens deals and comments before insert, set in InitRouter
+var contentScreener screening.Screener = screening.Pipeline{}
+
+// Tables of screened content
+var screeningTables = map[string]string{
+	"deal":    "deals",
+	"comment": "deal_comments",
+}
+
+// Screens content before insert, writing the error response if it is rejected or can't be screened.
+// Returns ok false when the handler should stop.
+func screenContent(w http.ResponseWriter, content screening.Content) (result screening.Result, ok bool) {
+	result, err := contentScreener.Screen(content)
+	if err != nil {
+		utils.WriteErrorJsonResponse(w, err.Error())
+		return result, false
+	}
+	if result.Verdict == screening.Reject {
+		recordScreening(content, "", result)
+		utils.WriteErrorJsonResponse(w, fmt.Sprintf("%s rejected: %s", content.Kind, strings.Join(result.Reasons, ", ")))
+		return result, false
+	}
+	return result, true
+}
+
+// Records held or rejected content for moderators. Held content is inserted with held_at set,
+// hiding it until approved.
+func recordScreening(content screening.Content, targetId string, result screening.Result) {
+	if result.Verdict == screening.Allow {
+		return
+	}
+	var target interface{}
+	if targetId != "" {
+		target = targetId
+	}
+	text := content.Text
+	if content.Title != "" {
+		text = content.Title + "\n" + content.Text
+	}
+	_, err := env.Db.Exec(`INSERT INTO content_screenings (author_id, target_type, target_id, verdict, reasons, content)
+		VALUES ($1, $2, $3, $4, $5, $6)`,
+		content.AuthorID, content.Kind, target, result.Verdict.String(), pq.Array(result.Reasons), text)
+	if err != nil {
+		log.Printf("error recording screening of %s by '%s': %s", content.Kind, content.AuthorID, err)
+	}
+}
+
+// Screened content for moderators, latest first.
+// Unreviewed held content by default, `verdict=reject` lists rejections, `reviewed=true` reviewed ones.
+func getContentScreenings(w http.ResponseWriter, r *http.Request) {
+	values := r.URL.Query()
+	verdict := values.Get("verdict")
+	if verdict == "" {
+		verdict = "hold"
+	}
+	reviewed, _ := strconv.ParseBool(values.Get("reviewed"))
+	pageSize := 30
+	if pageSizeNum, err := strconv.Atoi(values.Get("pageSize")); err == nil && pageSizeNum > 0 {
+		pageSize = pageSizeNum
+	}
+	offset := 0
+	if offsetNum, err := strconv.Atoi(values.Get("offset")); err == nil && offsetNum > 0 {
+		offset = offsetNum
+	}
+	rows, err := env.Db.Query(`SELECT id, author_id, target_type, target_id, verdict, reasons, content,
+		created_at, reviewed_at, resolution FROM content_screenings
+		WHERE verdict = $1 AND (reviewed_at NOTNULL) = $2
+		ORDER BY created_at DESC LIMIT $3 OFFSET $4`, verdict, reviewed, pageSize, offset)
+	if err != nil {
+		utils.WriteErrorJsonResponse(w, err.Error())
+		return
+	}
+	defer utils.CloseRows(rows)
+	screenings := []structs.ContentScreening{}
+	for rows.Next() {
+		var s structs.ContentScreening
+		err = rows.Scan(&s.ID, &s.AuthorID, &s.TargetType, &s.TargetID, &s.Verdict, pq.Array(&s.Reasons),
+			&s.Content, &s.CreatedAt, &s.ReviewedAt, &s.Resolution)
+		if err != nil {
+			utils.WriteErrorJsonResponse(w, err.Error())
+			return
+		}
+		screenings = append(screenings, s)
+	}
+	utils.WriteStructs(w, screenings)
+}
+
+// Review held content, body: {"action": "approve" | "reject"}.
+// Approving shows the content unless reports hid it too, rejecting keeps it hidden.
+func reviewContentScreening(w http.ResponseWriter, r *http.Request) {
+	screeningId, err := getURLParamUUID("screeningId", r)
+	if err != nil {
+		utils.WriteErrorJsonResponse(w, err.Error())
+		return
+	}
+	result, err := utils.ReadRequestToJson(r)
+	if err != nil {
+		utils.WriteErrorJsonResponse(w, err.Error())
+		return
+	}
+	resolutions := map[string]string{"approve": "approved", "reject": "rejected"}
+	action, _ := result["action"].(string)
+	resolution, ok := resolutions[action]
+	if !ok {
+		utils.WriteErrorJsonResponse(w, fmt.Sprintf("invalid action '%s'", action))
+		return
+	}
+	reviewerId, _ := utils.GetUserIdInSession(r)
+	reviewedId, err := runAuditedAction(r, "screening."+action, "screening", screeningId, nil,
+		func(tx *sql.Tx) (id string, err error) {
+			var targetType string
+			var targetId sql.NullString
+			err = tx.QueryRow(`UPDATE content_screenings
+				SET reviewed_at = timezone('utc', now()), reviewed_by = $2, resolution = $3
+				WHERE id = $1 AND verdict = 'hold' AND reviewed_at ISNULL
+				RETURNING id, target_type, target_id`, screeningId, reviewerId, resolution).Scan(&id, &targetType, &targetId)
+			if err == nil && action == "approve" && targetId.Valid {
+				_, err = tx.Exec(`UPDATE `+screeningTables[targetType]+` SET held_at = NULL WHERE id = $1`,
+					targetId.String)
+			}
+			return id, err
+		})
+	writeAdminActionResult(w, "held content", reviewedId, err)
+}
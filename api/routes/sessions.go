@@ -0,0 +1,79 @@
+package routes
+
+import (
+	"groupbuying.online/api/auth"
+	"groupbuying.online/api/env"
+	"groupbuying.online/api/utils"
+	"net/http"
+	"sort"
+)
+
+// Revokes all server side sessions and refresh tokens of a user, returning how many sessions were active.
+// Access tokens already issued stay valid until they expire.
+func logoutEverywhere(userId string) (int64, error) {
+	revoked, err := env.Sessions.RevokeAll(userId)
+	if err != nil {
+		return 0, err
+	}
+	return revoked, auth.RevokeUserRefreshTokens(env.Db, userId)
+}
+
+// Active cookie sessions and bearer clients of the user, last seen first,
+// with the session of this request marked as current
+func getUserSessions(w http.ResponseWriter, r *http.Request) {
+	userId, _ := utils.GetUserIdInSession(r)
+	sessions, err := env.Sessions.List(userId)
+	if err != nil {
+		utils.WriteErrorJsonResponse(w, err.Error())
+		return
+	}
+	if current, err := utils.GetCookieSession(r); err == nil {
+		for i := range sessions {
+			sessions[i].IsCurrent = sessions[i].ID == current.ID
+		}
+	}
+	families, err := auth.ListRefreshTokenFamilies(env.Db, userId)
+	if err != nil {
+		utils.WriteErrorJsonResponse(w, err.Error())
+		return
+	}
+	sessions = append(sessions, families...)
+	sort.SliceStable(sessions, func(i, j int) bool {
+		return sessions[i].LastSeenAt.After(sessions[j].LastSeenAt)
+	})
+	utils.WriteStructs(w, sessions)
+}
+
+// Log out one of the user's devices, a cookie session or the refresh tokens of a bearer client
+func revokeUserSession(w http.ResponseWriter, r *http.Request) {
+	sessionId, err := getURLParamUUID("sessionId", r)
+	if err != nil {
+		utils.WriteErrorJsonResponse(w, err.Error())
+		return
+	}
+	userId, _ := utils.GetUserIdInSession(r)
+	err = env.Sessions.Revoke(userId, sessionId)
+	if err == auth.ErrSessionNotFound {
+		err = auth.RevokeRefreshTokenFamily(env.Db, userId, sessionId)
+	}
+	if err != nil {
+		utils.WriteErrorJsonResponse(w, err.Error())
+		return
+	}
+	recordAudit(r, auditEntry{actorId: userId, action: "session.revoke", targetType: "user", targetId: userId,
+		details: map[string]interface{}{"sessionId": sessionId}})
+	utils.WriteSuccessJsonResponse(w, sessionId)
+}
+
+// Log out all of the user's devices, including this one
+func revokeAllUserSessions(w http.ResponseWriter, r *http.Request) {
+	userId, _ := utils.GetUserIdInSession(r)
+	revoked, err := logoutEverywhere(userId)
+	if err != nil {
+		utils.WriteErrorJsonResponse(w, err.Error())
+		return
+	}
+	recordAudit(r, auditEntry{actorId: userId, action: "session.revoke_all", targetType: "user", targetId: userId,
+		details: map[string]interface{}{"revoked": revoked}})
+	utils.WriteJsonResponse(w, "revoked", revoked)
+}
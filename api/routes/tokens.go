@@ -0,0 +1,120 @@
+package routes
+
+import (
+	"groupbuying.online/api/auth"
+	"groupbuying.online/api/env"
+	"groupbuying.online/api/structs"
+	"groupbuying.online/api/utils"
+	"net/http"
+	"time"
+)
+
+// Header of login requests of clients without cookies, "bearer" responds tokens instead of a session cookie
+const authModeHeader = "X-Auth-Mode"
+
+func wantsBearerTokens(r *http.Request) bool {
+	return r.Header.Get(authModeHeader) == "bearer"
+}
+
+func accessTokenTTL() time.Duration {
+	if env.Conf.AccessTokenMinutes <= 0 {
+		return 15 * time.Minute
+	}
+	return time.Duration(env.Conf.AccessTokenMinutes) * time.Minute
+}
+
+func refreshTokenTTL() time.Duration {
+	if env.Conf.RefreshTokenDays <= 0 {
+		return 30 * 24 * time.Hour
+	}
+	return time.Duration(env.Conf.RefreshTokenDays) * 24 * time.Hour
+}
+
+// Access token and a refresh token starting a new rotation family, issued on login
+func issueAuthTokens(userId string, r *http.Request) (*structs.AuthTokens, error) {
+	refreshToken, refreshExpiresAt, err := auth.IssueRefreshToken(env.Db, userId, r.UserAgent(),
+		utils.GetClientIP(r, env.Conf.TrustedProxies), refreshTokenTTL())
+	if err != nil {
+		return nil, err
+	}
+	return newAuthTokens(userId, refreshToken, refreshExpiresAt)
+}
+
+func newAuthTokens(userId string, refreshToken string, refreshExpiresAt time.Time) (*structs.AuthTokens, error) {
+	accessToken, accessExpiresAt, err := auth.IssueAccessToken(env.Conf.JWTKey, userId, accessTokenTTL())
+	if err != nil {
+		return nil, err
+	}
+	return &structs.AuthTokens{
+		AccessToken:           accessToken,
+		AccessTokenExpiresAt:  accessExpiresAt,
+		RefreshToken:          refreshToken,
+		RefreshTokenExpiresAt: refreshExpiresAt,
+	}, nil
+}
+
+// Exchange a refresh token for new access and refresh tokens, body: {"refreshToken": "..."}.
+// The old refresh token can't be used again, reusing it revokes all tokens rotated from the same login.
+func refreshAuthTokens(w http.ResponseWriter, r *http.Request) {
+	result, err := utils.ReadRequestToJson(r)
+	if err != nil {
+		utils.WriteErrorJsonResponse(w, err.Error())
+		return
+	}
+	refreshToken, _ := result["refreshToken"].(string)
+	if refreshToken == "" {
+		utils.WriteErrorJsonResponse(w, "invalid input")
+		return
+	}
+	userId, newRefreshToken, refreshExpiresAt, err := auth.RotateRefreshToken(env.Db, refreshToken, r.UserAgent(),
+		utils.GetClientIP(r, env.Conf.TrustedProxies), refreshTokenTTL())
+	if err == auth.ErrRevokedToken {
+		recordAudit(r, auditEntry{actorId: userId, action: "token.reuse", targetType: "user", targetId: userId})
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		utils.WriteErrorJsonResponse(w, err.Error())
+		return
+	}
+	var banned bool
+	err = env.Db.QueryRow(`SELECT is_user_sanctioned($1, 'ban')`, userId).Scan(&banned)
+	if err != nil {
+		utils.WriteErrorJsonResponse(w, err.Error())
+		return
+	}
+	if banned {
+		_ = auth.RevokeUserRefreshTokens(env.Db, userId)
+		w.WriteHeader(http.StatusForbidden)
+		utils.WriteErrorJsonResponse(w, "banned")
+		return
+	}
+	tokens, err := newAuthTokens(userId, newRefreshToken, refreshExpiresAt)
+	if err != nil {
+		utils.WriteErrorJsonResponse(w, err.Error())
+		return
+	}
+	utils.WriteStructs(w, tokens)
+}
+
+// Revoke a refresh token on logout of a bearer token client, body: {"refreshToken": "..."}.
+// Access tokens already issued stay valid until they expire.
+func revokeAuthToken(w http.ResponseWriter, r *http.Request) {
+	result, err := utils.ReadRequestToJson(r)
+	if err != nil {
+		utils.WriteErrorJsonResponse(w, err.Error())
+		return
+	}
+	refreshToken, _ := result["refreshToken"].(string)
+	if refreshToken == "" {
+		utils.WriteErrorJsonResponse(w, "invalid input")
+		return
+	}
+	userId, err := auth.RevokeRefreshToken(env.Db, refreshToken)
+	if err != nil {
+		utils.WriteErrorJsonResponse(w, err.Error())
+		return
+	}
+	recordAudit(r, auditEntry{actorId: userId, action: "user.logout", targetType: "user", targetId: userId,
+		details: map[string]interface{}{"authType": "token"}})
+	utils.WriteSuccessJsonResponse(w, "")
+}
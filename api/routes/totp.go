@@ -0,0 +1,287 @@
+package routes
+
+import (
+	"database/sql"
+	"errors"
+	"groupbuying.online/api/auth"
+	"groupbuying.online/api/env"
+	"groupbuying.online/api/structs"
+	"groupbuying.online/api/utils"
+	"net/http"
+	"time"
+)
+
+// Time to enter the code after the first login step
+const mfaTokenTTL = 5 * time.Minute
+
+var errWrongSecondFactor = errors.New("wrong or already used code")
+
+// Logs the user in, or when they have two factor auth responds a structs.MFAChallenge
+// without a session, to finish with loginTOTP
+func loginUser(user *structs.User, w http.ResponseWriter, r *http.Request) {
+	var hasTOTP bool
+	err := env.Db.QueryRow(`SELECT totp_enabled_at NOTNULL FROM users WHERE id = $1`, user.ID).Scan(&hasTOTP)
+	if err != nil {
+		utils.WriteErrorJsonResponse(w, err.Error())
+		return
+	}
+	if !hasTOTP {
+		saveSession(user, w, r)
+		respondUser(*user, w)
+		return
+	}
+	token, expiresAt, err := auth.IssueMFAChallenge(env.Db, user.ID, mfaTokenTTL)
+	if err != nil {
+		utils.WriteErrorJsonResponse(w, err.Error())
+		return
+	}
+	utils.WriteStructs(w, structs.MFAChallenge{MFARequired: true, MFAToken: token, ExpiresAt: expiresAt})
+}
+
+func readSecondFactor(result utils.UnstructuredJSON) (code string, recoveryCode string) {
+	code, _ = result["code"].(string)
+	recoveryCode, _ = result["recoveryCode"].(string)
+	return code, recoveryCode
+}
+
+// Checks a code of the user's authenticator, or when recoveryCode is set uses it up.
+// A code is accepted once, later codes of the same or earlier time steps are rejected.
+func verifySecondFactor(userId string, code string, recoveryCode string) error {
+	var res sql.Result
+	var err error
+	if recoveryCode != "" {
+		res, err = env.Db.Exec(`UPDATE user_recovery_codes SET used_at = timezone('utc', now())
+			WHERE user_id = $1 AND code_hash = $2 AND used_at ISNULL`, userId, auth.HashRecoveryCode(recoveryCode))
+	} else {
+		var secret string
+		err = env.Db.QueryRow(`SELECT totp_secret FROM users WHERE id = $1 AND totp_enabled_at NOTNULL`,
+			userId).Scan(&secret)
+		if err == sql.ErrNoRows {
+			return errors.New("two factor auth is not enabled")
+		}
+		if err != nil {
+			return err
+		}
+		step, ok := auth.VerifyTOTP(secret, code, time.Now())
+		if !ok {
+			return errWrongSecondFactor
+		}
+		res, err = env.Db.Exec(`UPDATE users SET totp_last_step = $2
+			WHERE id = $1 AND (totp_last_step ISNULL OR totp_last_step < $2)`, userId, step)
+	}
+	if err != nil {
+		return err
+	}
+	if count, err := res.RowsAffected(); err != nil || count == 0 {
+		return errWrongSecondFactor
+	}
+	return nil
+}
+
+// Replaces the user's recovery codes with new ones
+func replaceRecoveryCodes(db sqlRunner, userId string) ([]string, error) {
+	codes, err := auth.NewRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	if _, err = db.Exec(`DELETE FROM user_recovery_codes WHERE user_id = $1`, userId); err != nil {
+		return nil, err
+	}
+	for _, code := range codes {
+		_, err = db.Exec(`INSERT INTO user_recovery_codes (user_id, code_hash) VALUES ($1, $2)`,
+			userId, auth.HashRecoveryCode(code))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return codes, nil
+}
+
+// Second login step, body: {"mfaToken": "...", "code": "123456"} or {"mfaToken": "...", "recoveryCode": "..."}.
+// The token is used up by a correct code or after auth.MaxMFAChallengeAttempts codes.
+func loginTOTP(w http.ResponseWriter, r *http.Request) {
+	result, err := utils.ReadRequestToJson(r)
+	if err != nil {
+		utils.WriteErrorJsonResponse(w, err.Error())
+		return
+	}
+	mfaToken, _ := result["mfaToken"].(string)
+	code, recoveryCode := readSecondFactor(result)
+	userId, err := auth.StartMFAAttempt(env.Db, mfaToken)
+	if err == auth.ErrTooManyMFAFailures {
+		w.WriteHeader(http.StatusTooManyRequests)
+		utils.WriteErrorJsonResponse(w, err.Error())
+		return
+	}
+	if err == nil {
+		err = verifySecondFactor(userId, code, recoveryCode)
+		if err == errWrongSecondFactor {
+			recordAudit(r, auditEntry{actorId: userId, action: "user.totp_fail", targetType: "user", targetId: userId})
+		}
+	}
+	if err == nil {
+		err = auth.ConsumeMFAChallenge(env.Db, mfaToken)
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		utils.WriteErrorJsonResponse(w, err.Error())
+		return
+	}
+	user, err := getAuthUserById(userId)
+	if err != nil {
+		utils.WriteErrorJsonResponse(w, err.Error())
+		return
+	}
+	saveSession(&user, w, r)
+	respondUser(user, w)
+}
+
+// Starts enrolling an authenticator, responds a structs.TOTPEnrollment.
+// Two factor auth is only enabled once a code of it is sent to confirmTOTPEnrollment.
+func startTOTPEnrollment(w http.ResponseWriter, r *http.Request) {
+	userId, _ := utils.GetUserIdInSession(r)
+	secret, err := auth.NewTOTPSecret()
+	if err != nil {
+		utils.WriteErrorJsonResponse(w, err.Error())
+		return
+	}
+	var account string
+	err = env.Db.QueryRow(`UPDATE users SET totp_secret = $2, totp_last_step = NULL
+		WHERE id = $1 AND totp_enabled_at ISNULL RETURNING COALESCE(email, display_name)`,
+		userId, secret).Scan(&account)
+	if err == sql.ErrNoRows {
+		w.WriteHeader(http.StatusConflict)
+		utils.WriteErrorJsonResponse(w, "two factor auth is already enabled")
+		return
+	}
+	if err != nil {
+		utils.WriteErrorJsonResponse(w, err.Error())
+		return
+	}
+	utils.WriteStructs(w, structs.TOTPEnrollment{
+		Secret: secret,
+		URI:    auth.TOTPProvisioningURI(env.Conf.TOTPIssuer, account, secret),
+	})
+}
+
+// Enables two factor auth with a code of the enrolled authenticator, body: {"code": "123456"}.
+// Responds the recovery codes, they are only shown this once.
+func confirmTOTPEnrollment(w http.ResponseWriter, r *http.Request) {
+	userId, _ := utils.GetUserIdInSession(r)
+	result, err := utils.ReadRequestToJson(r)
+	if err != nil {
+		utils.WriteErrorJsonResponse(w, err.Error())
+		return
+	}
+	code, _ := readSecondFactor(result)
+	var secret sql.NullString
+	err = env.Db.QueryRow(`SELECT totp_secret FROM users WHERE id = $1 AND totp_enabled_at ISNULL`,
+		userId).Scan(&secret)
+	if err == sql.ErrNoRows || (err == nil && !secret.Valid) {
+		w.WriteHeader(http.StatusConflict)
+		utils.WriteErrorJsonResponse(w, "no authenticator to confirm, start enrolling first")
+		return
+	}
+	if err != nil {
+		utils.WriteErrorJsonResponse(w, err.Error())
+		return
+	}
+	step, ok := auth.VerifyTOTP(secret.String, code, time.Now())
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		utils.WriteErrorJsonResponse(w, errWrongSecondFactor.Error())
+		return
+	}
+
+	tx, err := env.Db.Begin()
+	if err != nil {
+		utils.WriteErrorJsonResponse(w, err.Error())
+		return
+	}
+	var codes []string
+	_, err = tx.Exec(`UPDATE users SET totp_enabled_at = timezone('utc', now()), totp_last_step = $2
+		WHERE id = $1`, userId, step)
+	if err == nil {
+		codes, err = replaceRecoveryCodes(tx, userId)
+	}
+	if err == nil {
+		err = tx.Commit()
+	} else {
+		_ = tx.Rollback()
+	}
+	if err != nil {
+		utils.WriteErrorJsonResponse(w, err.Error())
+		return
+	}
+	recordAudit(r, auditEntry{actorId: userId, action: "user.totp_enable", targetType: "user", targetId: userId})
+	utils.WriteJsonResponse(w, "recoveryCodes", codes)
+}
+
+// Replaces the recovery codes, e.g. when they ran out, body: {"code": "123456"}
+func regenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	userId, _ := utils.GetUserIdInSession(r)
+	result, err := utils.ReadRequestToJson(r)
+	if err != nil {
+		utils.WriteErrorJsonResponse(w, err.Error())
+		return
+	}
+	code, _ := readSecondFactor(result)
+	if err = verifySecondFactor(userId, code, ""); err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		utils.WriteErrorJsonResponse(w, err.Error())
+		return
+	}
+	codes, err := replaceRecoveryCodes(env.Db, userId)
+	if err != nil {
+		utils.WriteErrorJsonResponse(w, err.Error())
+		return
+	}
+	recordAudit(r, auditEntry{actorId: userId, action: "user.totp_recovery_codes", targetType: "user", targetId: userId})
+	utils.WriteJsonResponse(w, "recoveryCodes", codes)
+}
+
+// Clears the authenticator secret and recovery codes of userId
+func clearTOTP(db sqlRunner, userId string) (string, error) {
+	var id string
+	err := db.QueryRow(`UPDATE users SET totp_secret = NULL, totp_enabled_at = NULL, totp_last_step = NULL
+		WHERE id = $1 RETURNING id`, userId).Scan(&id)
+	if err == nil {
+		_, err = db.Exec(`DELETE FROM user_recovery_codes WHERE user_id = $1`, userId)
+	}
+	return id, err
+}
+
+// Disables two factor auth, body: {"code": "123456"} or {"recoveryCode": "..."}
+func disableTOTP(w http.ResponseWriter, r *http.Request) {
+	userId, _ := utils.GetUserIdInSession(r)
+	result, err := utils.ReadRequestToJson(r)
+	if err != nil {
+		utils.WriteErrorJsonResponse(w, err.Error())
+		return
+	}
+	code, recoveryCode := readSecondFactor(result)
+	if err = verifySecondFactor(userId, code, recoveryCode); err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		utils.WriteErrorJsonResponse(w, err.Error())
+		return
+	}
+	if _, err = clearTOTP(env.Db, userId); err != nil {
+		utils.WriteErrorJsonResponse(w, err.Error())
+		return
+	}
+	recordAudit(r, auditEntry{actorId: userId, action: "user.totp_disable", targetType: "user", targetId: userId})
+	utils.WriteSuccessJsonResponse(w, "")
+}
+
+// Turns off two factor auth of a user who lost their authenticator and recovery codes
+func resetUserTOTP(w http.ResponseWriter, r *http.Request) {
+	userId, err := getURLParamUUID("userId", r)
+	if err != nil {
+		utils.WriteErrorJsonResponse(w, err.Error())
+		return
+	}
+	resetId, err := runAuditedAction(r, "user.totp_reset", "user", userId, nil, func(tx *sql.Tx) (string, error) {
+		return clearTOTP(tx, userId)
+	})
+	writeAdminActionResult(w, "user", resetId, err)
+}
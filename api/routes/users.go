@@ -1,14 +1,17 @@
 package routes
 
 import (
-	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"github.com/asaskevich/govalidator"
+	"groupbuying.online/api/auth"
 	"groupbuying.online/api/env"
+	"groupbuying.online/api/identity"
+	"groupbuying.online/api/middleware"
 	"groupbuying.online/api/structs"
 	"groupbuying.online/api/utils"
+	"log"
 	"net/http"
 	"strings"
 	"time"
@@ -21,8 +24,9 @@ func getUserById(w http.ResponseWriter, r *http.Request) {
 	if err != nil || userId == "" {
 		w.WriteHeader(http.StatusBadRequest)
 	}
-	err = env.Db.QueryRow("SELECT image_url, display_name, country_code, fir_id FROM users WHERE id=$1",
-		userId).Scan(&user.ImageURL, &user.DisplayName, &user.CountryCode, &user.FIRID)
+	err = env.Db.QueryRow("SELECT image_url, display_name, COALESCE(country_code, ''), bio, " +
+		"COALESCE(fir_id, '') FROM users WHERE id=$1",
+		userId).Scan(&user.ImageURL, &user.DisplayName, &user.CountryCode, &user.Bio, &user.FIRID)
 	if err != nil {
 		utils.WriteError(w, "user not found")
 		return
@@ -33,16 +37,39 @@ func getUserById(w http.ResponseWriter, r *http.Request) {
 	utils.CheckFatalError(w, err)
 }
 
-// Used by login methods, response includes auth info
-func getUserByEmail(email string) (user structs.User, err error) {
+var errIdentityNotLinked = errors.New("sign in not linked to an account")
+
+// Used by login methods, the user linked to the identity of verified sign in claims.
+// Identities migrated without their subject are matched once by a provider verified email and get it set.
+func getUserByIdentity(claims identity.Identity) (user structs.User, err error) {
+	var userId string
+	err = env.Db.QueryRow(`WITH linked AS (
+			SELECT user_id FROM user_identities WHERE provider = $1 AND subject = $2
+		), migrated AS (
+			UPDATE user_identities SET subject = $2
+			WHERE provider = $1 AND subject ISNULL AND email = $3 AND $4 AND NOT EXISTS (SELECT 1 FROM linked)
+			RETURNING user_id
+		) SELECT user_id FROM linked UNION ALL SELECT user_id FROM migrated`,
+		claims.Provider, claims.Subject, claims.Email, claims.EmailVerified).Scan(&userId)
+	if err == sql.ErrNoRows {
+		return user, errIdentityNotLinked
+	}
+	if err != nil {
+		return user, err
+	}
+	return getAuthUserById(userId)
+}
+
+// Response includes auth info, banned users are not found
+func getAuthUserById(userId string) (user structs.User, err error) {
 	err = env.Db.QueryRow("SELECT id, image_url, display_name, " +
-		"country_code, auth_type, email, fir_id " +
+		"COALESCE(country_code, ''), bio, auth_type, email, COALESCE(fir_id, ''), role " +
 		"FROM users u " +
-		"WHERE email=$1 " +
-		"AND NOT EXISTS (SELECT user_id FROM users_banned u_b WHERE u_b.user_id=u.id)",
-		email).Scan(
+		"WHERE id=$1 " +
+		"AND NOT is_user_sanctioned(u.id, 'ban')",
+		userId).Scan(
 			&user.ID, &user.ImageURL, &user.DisplayName,
-			&user.CountryCode, &user.AuthType, &user.Email, &user.FIRID)
+			&user.CountryCode, &user.Bio, &user.AuthType, &user.Email, &user.FIRID, &user.Role)
 	if err != nil {
 		return user, fmt.Errorf("user not found")
 	} else {
@@ -50,17 +77,33 @@ func getUserByEmail(email string) (user structs.User, err error) {
 	}
 }
 
+// Token to send in the X-CSRF-Token header of POST, PUT and DELETE requests with this session
+func getCSRFToken(w http.ResponseWriter, r *http.Request) {
+	token, err := middleware.IssueCSRFToken(env.Store, env.Conf, w, r)
+	if err != nil {
+		utils.WriteErrorJsonResponse(w, err.Error())
+		return
+	}
+	w.Header().Set(middleware.CSRFHeader, token)
+	utils.WriteJsonResponse(w, "csrfToken", token)
+}
+
 // Auth
 func logoutUser(w http.ResponseWriter, r *http.Request) {
-	session, _ := env.Store.Get(r, env.Conf.SessionName)
-	session.Values["authenticated"] = false
-	delete(session.Values, "userId")
-	err := session.Save(r, w)
+	if session, err := utils.GetCookieSession(r); err == nil {
+		err = env.Sessions.Revoke(session.UserID, session.ID)
+		utils.CheckFatalError(w, err)
+		recordAudit(r, auditEntry{actorId: session.UserID, action: "user.logout", targetType: "user", targetId: session.UserID,
+			details: map[string]interface{}{"sessionId": session.ID}})
+	}
+	cookieSession, _ := env.Store.Get(r, env.Conf.SessionName)
+	delete(cookieSession.Values, auth.CookieSessionKey)
+	err := cookieSession.Save(r, w)
 	utils.CheckFatalError(w, err)
 	utils.WriteSuccessJsonResponse(w, "")
 }
 
-// Insert a new user with unverified email
+// Insert a new user signed in with Firebase email and password, the email is verified if Firebase verified it
 func registerEmailUser(w http.ResponseWriter, r *http.Request) {
 	creds := &structs.UserCredentials{}
 	authType := "email"
@@ -70,16 +113,17 @@ func registerEmailUser(w http.ResponseWriter, r *http.Request) {
 	err = utils.IsValidUsername(creds.DisplayName)
 	utils.CheckFatalError(w, err)
 
-	err = verifyToken(creds.Token)
-	utils.CheckFatalError(w, err)
+	claims, ok := verifyIdentity(w, r, authType, creds.Token)
+	if !ok {
+		return
+	}
+	// the Firebase user id is the subject of Firebase sign in tokens
+	creds.Email, creds.FIRID = claims.Email, claims.Subject
 
-	var userId string
-	creds.Email = strings.ToLower(creds.Email)
-	err = env.Db.QueryRow("INSERT INTO USERS " +
-		"(email, display_name, auth_type, country_code, fir_id) " +
-		"VALUES ($1, $2, $3, $4, $5) RETURNING id;",
-		creds.Email, creds.DisplayName, authType, creds.CountryCode, creds.FIRID).Scan(&userId)
-	utils.CheckFatalError(w, err)
+	userId, err := insertRegisteredUser(claims, creds.DisplayName, "", creds.CountryCode, creds.FIRID)
+	if !writeRegisterError(w, err) {
+		return
+	}
 
 	user := structs.User{
 		ID: userId,
@@ -89,43 +133,119 @@ func registerEmailUser(w http.ResponseWriter, r *http.Request) {
 		Email: &creds.Email,
 		FIRID: creds.FIRID,
 	}
+	recordAudit(r, auditEntry{actorId: userId, action: "user.register", targetType: "user", targetId: userId,
+		details: map[string]interface{}{"authType": authType}})
 	respondUser(user, w)
 }
 
-func verifyToken(idToken string) error {
-	ctx := context.Background()
-	client, err := env.Firebase.Auth(ctx)
+var errAlreadyRegistered = errors.New("email or account already registered, log in instead")
+
+// Inserts a user with the email of verified sign in claims and links their identity,
+// errAlreadyRegistered if either is taken, errDisplayNameTaken if another user has the display name
+func insertRegisteredUser(claims identity.Identity, displayName string, imageUrl string, countryCode string,
+	firId string) (userId string, err error) {
+	tx, err := env.Db.Begin()
+	if err != nil {
+		return "", err
+	}
+	err = checkDisplayNameFree(tx, displayName, "")
+	if err == nil {
+		err = tx.QueryRow(`INSERT INTO users
+			(email, display_name, image_url, auth_type, country_code, fir_id, email_verified_at)
+			VALUES ($1, $2, NULLIF($3, ''), $4, NULLIF($5, ''), $6, CASE WHEN $7 THEN timezone('utc', now()) END)
+			ON CONFLICT DO NOTHING RETURNING id`,
+			claims.Email, displayName, imageUrl, claims.Provider, countryCode, firId, claims.EmailVerified).Scan(&userId)
+	}
+	if err == nil {
+		err = insertUserIdentity(tx, userId, claims)
+	}
+	if err == sql.ErrNoRows {
+		err = errAlreadyRegistered
+	}
 	if err != nil {
-		return err
+		_ = tx.Rollback()
+		return "", err
+	}
+	return userId, tx.Commit()
+}
+
+// Writes the error response of a failed registration, false if there was one
+func writeRegisterError(w http.ResponseWriter, err error) bool {
+	if err == nil {
+		return true
 	}
+	if err == errAlreadyRegistered || err == errDisplayNameTaken {
+		w.WriteHeader(http.StatusConflict)
+	} else {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+	utils.WriteErrorJsonResponse(w, err.Error())
+	return false
+}
 
-	_, err = client.VerifyIDToken(ctx, idToken)
+// Set in InitRouter, see package identity
+var identityProviders identity.Providers
+
+// Claims of a sign in token verified by provider, writes the error response if it is invalid
+func verifyIdentity(w http.ResponseWriter, r *http.Request, provider string, token string) (identity.Identity, bool) {
+	claims, err := identityProviders.Verify(r.Context(), provider, token)
+	switch {
+	case err == identity.ErrInvalidToken || err == identity.ErrUnknownProvider:
+		w.WriteHeader(http.StatusUnauthorized)
+	case err != nil:
+		log.Printf("error verifying %s sign in token: %s", provider, err)
+		w.WriteHeader(http.StatusBadGateway)
+	case claims.Email == "":
+		err = fmt.Errorf("no email from %s sign in", provider)
+		w.WriteHeader(http.StatusUnauthorized)
+	}
 	if err != nil {
-		return err
+		utils.WriteErrorJsonResponse(w, err.Error())
+		return claims, false
 	}
-	return nil
+	return claims, true
 }
 
 func loginEmailUser(w http.ResponseWriter, r *http.Request) {
 	result, err := utils.ReadRequestToJson(r)
 	utils.CheckFatalError(w, err)
 
-	email := result["email"].(string)
-	token := result["token"].(string)
-	if email == "" || token == "" {
+	token, _ := result["token"].(string)
+	if token == "" {
 		w.WriteHeader(http.StatusBadRequest)
 		utils.WriteError(w, "invalid input")
 		return
 	}
-	err = verifyToken(token)
-	utils.CheckFatalError(w, err)
-
-	user, err := getUserByEmail(email)
-	utils.CheckFatalError(w, err)
+	claims, ok := verifyIdentity(w, r, "email", token)
+	if !ok {
+		return
+	}
+	loginIdentity(w, r, claims)
+}
 
-	// Save authenticated session if successful
-	saveSession(user, w, r)
-	respondUser(user, w)
+// Saves an authenticated session for the user linked to claims and responds with the user,
+// or with {"to_register": true} if there is none and the email is not registered either
+func loginIdentity(w http.ResponseWriter, r *http.Request, claims identity.Identity) {
+	user, err := getUserByIdentity(claims)
+	if err == errIdentityNotLinked {
+		var isRegistered bool
+		err = env.Db.QueryRow(`SELECT EXISTS (SELECT 1 FROM users WHERE email = $1)`,
+			claims.Email).Scan(&isRegistered)
+		if err == nil && !isRegistered {
+			writeToRegisterJson(w)
+			return
+		}
+		if err == nil {
+			// linking needs the other sign in method, see linkUserIdentity
+			w.WriteHeader(http.StatusConflict)
+			err = fmt.Errorf("email registered with another sign in method, log in with it to link %s", claims.Provider)
+		}
+	}
+	if err != nil {
+		utils.WriteErrorJsonResponse(w, err.Error())
+		return
+	}
+	loginUser(&user, w, r)
 }
 
 func writeToRegisterJson(w http.ResponseWriter) {
@@ -139,12 +259,27 @@ func readSocialCredentials(r *http.Request) (*structs.SocialSignInCredentials, e
 	return creds, err
 }
 
-func saveSession(user structs.User, w http.ResponseWriter, r *http.Request) {
-	session, _ := env.Store.Get(r, env.Conf.SessionName)
-	session.Values["authenticated"] = true
-	session.Values["userId"] = user.ID
-	err := session.Save(r, w)
-	utils.CheckFatalError(w, err)
+// Starts a server side session in the cookie, replacing any previous one,
+// or when the client asks for bearer auth sets tokens on the user instead
+func saveSession(user *structs.User, w http.ResponseWriter, r *http.Request) {
+	bearer := wantsBearerTokens(r)
+	if bearer {
+		var err error
+		user.Tokens, err = issueAuthTokens(user.ID, r)
+		utils.CheckFatalError(w, err)
+	} else {
+		if previous, err := utils.GetCookieSession(r); err == nil {
+			_ = env.Sessions.Revoke(previous.UserID, previous.ID)
+		}
+		_, token, err := env.Sessions.Create(user.ID, r.UserAgent(), utils.GetClientIP(r, env.Conf.TrustedProxies))
+		utils.CheckFatalError(w, err)
+		cookieSession, _ := env.Store.Get(r, env.Conf.SessionName)
+		cookieSession.Values[auth.CookieSessionKey] = token
+		err = cookieSession.Save(r, w)
+		utils.CheckFatalError(w, err)
+	}
+	recordAudit(r, auditEntry{actorId: user.ID, action: "user.login", targetType: "user", targetId: user.ID,
+		details: map[string]interface{}{"authType": user.AuthType, "bearer": bearer}})
 }
 
 func respondUser(user structs.User, w http.ResponseWriter) {
@@ -162,32 +297,11 @@ func loginGoogleUser(w http.ResponseWriter, r *http.Request) {
 	creds, err := readSocialCredentials(r)
 	utils.CheckFatalError(w, err)
 
-	isValid := validateGoogleUserToken(creds.Email, creds.UserToken)
-	if !isValid {
-		w.WriteHeader(http.StatusInternalServerError)
+	claims, ok := verifyIdentity(w, r, "google", creds.UserToken)
+	if !ok {
 		return
 	}
-	user, err := getUserByEmail(creds.Email)
-	if err == nil {
-		saveSession(user, w, r)
-		respondUser(user, w)
-	} else {
-		writeToRegisterJson(w)
-	}
-}
-
-// Check if token's email matches token supplied
-func validateGoogleUserToken(email string, userToken string) bool {
-	validateTokenLink := fmt.Sprintf(
-		"https://www.googleapis.com/oauth2/v3/tokeninfo?id_token=%s", userToken)
-	resp, err := http.Get(validateTokenLink)
-	if err != nil {
-		return false
-	}
-	defer utils.CloseResponse(resp)
-	jsonResp, err := utils.ReadResponseToJson(resp)
-	isValid := jsonResp["email"].(string) == email
-	return isValid
+	loginIdentity(w, r, claims)
 }
 
 // Facebook Auth
@@ -198,63 +312,56 @@ func loginFacebookUser(w http.ResponseWriter, r *http.Request) {
 	creds, err := readSocialCredentials(r)
 	utils.CheckFatalError(w, err)
 
-	appToken, err := getFacebookAppToken()
-	utils.CheckFatalError(w, err)
-
-	isValid := validateFacebookUserToken(appToken, creds.UserToken, creds.UserID)
-	if !isValid {
-		w.WriteHeader(http.StatusInternalServerError)
+	claims, ok := verifyIdentity(w, r, "facebook", creds.UserToken)
+	if !ok {
 		return
 	}
-	user, err := getUserByEmail(creds.Email)
-	if err == nil {
-		saveSession(user, w, r)
-		respondUser(user, w)
-	} else {
-		writeToRegisterJson(w)
-	}
-}
-
-func getFacebookAppToken() (appToken string, err error) {
-	clientId := env.Conf.FBAppId
-	clientSecret := env.Conf.FBAppSecret
-	appLink := "https://graph.facebook.com/oauth/access_token?client_id=" + clientId +
-		"&client_secret=" + clientSecret + "&grant_type=client_credentials"
-
-	resp, err := http.Get(appLink)
-	if err != nil {
-		return "", fmt.Errorf("could not get FB App Token")
-	}
-	defer utils.CloseResponse(resp)
-	jsonResp, err := utils.ReadResponseToJson(resp)
-	appToken = jsonResp["access_token"].(string)
-	return appToken, nil
-}
-
-func validateFacebookUserToken(appToken string, userToken string, userId string) (bool) {
-	// Checks user token is valid and user_id in response is same as given userId
-	validateTokenLink := "https://graph.facebook.com/debug_token?input_token="+ userToken +
-		"&access_token=" + appToken
-	resp, err := http.Get(validateTokenLink)
-	if err != nil {
-		return false
+	if claims.Subject != creds.UserID {
+		w.WriteHeader(http.StatusUnauthorized)
+		utils.WriteErrorJsonResponse(w, identity.ErrInvalidToken.Error())
+		return
 	}
-	defer utils.CloseResponse(resp)
-	jsonResp, err := utils.ReadResponseToJson(resp)
-	jsonRespData := jsonResp["data"].(utils.UnstructuredJSON)
-	isValid := jsonRespData["is_valid"].(bool) && jsonRespData["user_id"].(string) == userId
-	return isValid
+	loginIdentity(w, r, claims)
 }
 
+// Register with a Google or Facebook sign in token, verified like at login.
+// The email and provider subject are taken from its claims, display name and image default to them.
 func registerBySocialMedia(w http.ResponseWriter, r *http.Request) {
 	creds := &structs.UserCredentialSocialMedia{}
-	err := json.NewDecoder(r.Body).Decode(creds)
+	if err := json.NewDecoder(r.Body).Decode(creds); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		utils.WriteErrorJsonResponse(w, err.Error())
+		return
+	}
+	if creds.AuthType != "google" && creds.AuthType != "facebook" {
+		w.WriteHeader(http.StatusBadRequest)
+		utils.WriteErrorJsonResponse(w, fmt.Sprintf("invalid auth type '%s'", creds.AuthType))
+		return
+	}
+	claims, ok := verifyIdentity(w, r, creds.AuthType, creds.UserToken)
+	if !ok {
+		return
+	}
+	if creds.DisplayName == "" {
+		creds.DisplayName = claims.DisplayName
+	}
+	if creds.ImageUrl == "" {
+		creds.ImageUrl = claims.ImageURL
+	}
+	// provider names often have spaces, clients then ask for another one
+	if err := utils.IsValidUsername(creds.DisplayName); err != nil || creds.DisplayName == "" {
+		if err == nil {
+			err = fmt.Errorf("missing display name")
+		}
+		w.WriteHeader(http.StatusBadRequest)
+		utils.WriteErrorJsonResponse(w, err.Error())
+		return
+	}
 
-	var id string
-	err = env.Db.QueryRow("INSERT INTO users (email, display_name, image_url, auth_type, country_code, fir_id)" +
-		" VALUES ($1, $2, $3, $4, $5, $6) RETURNING id;",
-		creds.Email, creds.DisplayName, creds.ImageUrl, creds.AuthType, creds.CountryCode, creds.FIRID).Scan(&id)
-	utils.CheckFatalError(w, err)
+	id, err := insertRegisteredUser(claims, creds.DisplayName, creds.ImageUrl, creds.CountryCode, creds.FIRID)
+	if !writeRegisterError(w, err) {
+		return
+	}
 
 	user := structs.User{
 		ID: id,
@@ -262,46 +369,15 @@ func registerBySocialMedia(w http.ResponseWriter, r *http.Request) {
 		DisplayName: creds.DisplayName,
 		CountryCode: creds.CountryCode,
 		AuthType: &creds.AuthType,
-		Email: &creds.Email,
+		Email: &claims.Email,
 		FIRID: creds.FIRID,
 	}
-	saveSession(user, w, r)
+	recordAudit(r, auditEntry{actorId: id, action: "user.register", targetType: "user", targetId: id,
+		details: map[string]interface{}{"authType": creds.AuthType}})
+	saveSession(&user, w, r)
 	respondUser(user, w)
 }
 
-func updateUser(w http.ResponseWriter, r *http.Request) {
-	result, err := utils.ReadRequestToJson(r)
-	utils.CheckFatalError(w, err)
-
-	displayName := result["displayName"].(string)
-	countryCode := result["countryCode"].(string)
-	imageUrl := result["imageUrl"].(string)
-	userId := result["userId"].(string)
-	if displayName == "" || countryCode == ""  {
-		utils.WriteErrorJsonResponse(w, "missing fields")
-		return
-	}
-	if !govalidator.IsISO3166Alpha2(countryCode) || !utils.IsValidUUID(userId) {
-		utils.WriteErrorJsonResponse(w, "invalid fields")
-		return
-	}
-	if imageUrl != "" && !govalidator.IsURL(imageUrl) {
-		utils.WriteErrorJsonResponse(w, "invalid image")
-		return
-	}
-	query := "UPDATE users SET display_name=$1, country_code=$2"
-	queryParams := []interface{}{ displayName, countryCode }
-	if imageUrl != "" {
-		query += ", image_url=$3"
-		queryParams = append(queryParams, imageUrl)
-	}
-	query += fmt.Sprintf(" WHERE id='%s' RETURNING id", userId)
-	err = env.Db.QueryRow(query, queryParams...).Scan(&userId)
-	utils.CheckFatalError(w, err)
-
-	utils.WriteSuccessJsonResponse(w, "updated user")
-}
-
 func blockUser(w http.ResponseWriter, r *http.Request) {
 	result, err := utils.ReadRequestToJson(r)
 	utils.CheckFatalError(w, err)
@@ -321,8 +397,9 @@ func blockUser(w http.ResponseWriter, r *http.Request) {
 			`INSERT INTO users_blocked (user_id, blocked_id) VALUES ($1, $2) RETURNING id`,
 			userId, blockedId).Scan(&tupleId)
 		utils.CheckFatalError(w, err)
+		recordAudit(r, auditEntry{actorId: userId, action: "user.block", targetType: "user", targetId: blockedId})
 		var blockedFirId string
-		err = env.Db.QueryRow(`SELECT fir_id FROM users WHERE id=$1`, blockedId).Scan(&blockedFirId)
+		err = env.Db.QueryRow(`SELECT COALESCE(fir_id, '') FROM users WHERE id=$1`, blockedId).Scan(&blockedFirId)
 		utils.CheckFatalError(w, err)
 		utils.WriteJsonResponse(w, "blockedFirId", blockedFirId)
 	case http.MethodDelete:
@@ -330,6 +407,7 @@ func blockUser(w http.ResponseWriter, r *http.Request) {
 			`DELETE FROM users_blocked WHERE user_id = $1 AND blocked_id = $2 RETURNING id`,
 			userId, blockedId).Scan(&tupleId)
 		utils.CheckFatalError(w, err)
+		recordAudit(r, auditEntry{actorId: userId, action: "user.unblock", targetType: "user", targetId: blockedId})
 		utils.WriteSuccessJsonResponse(w, tupleId)
 	}
 }
@@ -343,19 +421,25 @@ func reportUser(w http.ResponseWriter, r *http.Request) {
 	reason, ok3 := result["reason"].(string)
 	reqUserId, ok4 := utils.GetUserIdInSession(r)
 
-	if !utils.IsValidUUID(reporterId) || !ok1 || !ok2 || !ok3 || !ok4 || reqUserId != reporterId {
+	if !utils.IsValidUUID(reporterId) || !utils.IsValidUUID(reportedId) ||
+		!ok1 || !ok2 || !ok3 || !ok4 || reqUserId != reporterId {
 		utils.WriteErrorJsonResponse(w,"invalid input")
 		return
 	}
+	reasonCode, ok := result["reasonCode"].(string)
+	if !ok {
+		reasonCode = "other"
+	}
 
-	var tupleId string
-	err = env.Db.QueryRow(`
-		INSERT INTO users_reported (reporter_id, reported_id, reason) 
-		VALUES ($1, $2, $3) RETURNING id`, reporterId, reportedId, reason).Scan(&tupleId)
-	utils.CheckFatalError(w, err)
-	utils.WriteSuccessJsonResponse(w, tupleId)
+	reportId, err := createContentReport(r, reporterId, "user", reportedId, reasonCode, reason)
+	if err != nil {
+		utils.WriteErrorJsonResponse(w, err.Error())
+		return
+	}
+	utils.WriteSuccessJsonResponse(w, reportId)
 }
 
+// Sanctions of the session user that they are told about, shadow bans are not included
 func isUserBanned(w http.ResponseWriter, r *http.Request) {
 	userId, ok := utils.GetUserIdInSession(r)
 	if !ok || !utils.IsValidUUID(userId) {
@@ -363,12 +447,66 @@ func isUserBanned(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var banDate time.Time
-	key := "isBanned"
-	if err := env.Db.QueryRow(`SELECT created_at FROM users_banned WHERE user_id=$1`,
-		userId).Scan(&banDate); err == sql.ErrNoRows {
-		utils.WriteJsonResponse(w, key, false)
-	} else {
-		utils.WriteJsonResponse(w, key, true)
+	var suspendedUntil *time.Time
+	var reason *string
+	err := env.Db.QueryRow(`SELECT expires_at, reason FROM users_banned
+		WHERE user_id=$1 AND kind='suspension' AND is_user_sanctioned(user_id, kind)`,
+		userId).Scan(&suspendedUntil, &reason)
+	if err != nil && err != sql.ErrNoRows {
+		utils.WriteErrorJsonResponse(w, err.Error())
+		return
+	}
+	// banned users are logged out by the auth middleware before reaching here
+	utils.WriteStructs(w, map[string]interface{}{
+		"isBanned": false,
+		"isSuspended": err == nil,
+		"suspendedUntil": suspendedUntil,
+		"reason": reason,
+	})
+}
+
+// Filter for rows where the users in userCol and viewerExpr have not blocked each other, in either direction.
+// viewerExpr is a column, placeholder or quoted validated uuid, NULL (logged out) filters nothing.
+func getNotBlockedFilter(userCol string, viewerExpr string) string {
+	return fmt.Sprintf(`NOT EXISTS (SELECT 1 FROM users_blocked u_b
+		WHERE (u_b.user_id=%s AND u_b.blocked_id=%s) OR (u_b.user_id=%s AND u_b.blocked_id=%s))`,
+		viewerExpr, userCol, userCol, viewerExpr)
+}
+
+// Filter for rows where the user in userCol is not shadow banned, unless they are the viewer.
+// viewerExpr is as in getNotBlockedFilter, when NULL all shadow banned users are filtered.
+func getNotShadowBannedFilter(userCol string, viewerExpr string) string {
+	return fmt.Sprintf(`(%s=%s OR NOT is_user_sanctioned(%s, 'shadow'))`, userCol, viewerExpr, userCol)
+}
+
+// Users blocked by the session user, most recent first
+func getBlockedUsers(w http.ResponseWriter, r *http.Request) {
+	userId, ok := utils.GetUserIdInSession(r)
+	if !ok {
+		utils.WriteErrorJsonResponse(w, "invalid input")
+		return
+	}
+	rows, err := env.Db.Query(`SELECT u.id, u.display_name, u.image_url, u.fir_id, u_b.created_at
+		FROM users_blocked u_b INNER JOIN users u ON u.id = u_b.blocked_id
+		WHERE u_b.user_id = $1
+		ORDER BY u_b.created_at DESC`, userId)
+	if err != nil {
+		utils.WriteErrorJsonResponse(w, err.Error())
+		return
+	}
+	defer utils.CloseRows(rows)
+	blockedUsers := []structs.BlockedUser{}
+	for rows.Next() {
+		var blockedUser structs.BlockedUser
+		var firId sql.NullString
+		err = rows.Scan(&blockedUser.User.ID, &blockedUser.User.DisplayName, &blockedUser.User.ImageURL,
+			&firId, &blockedUser.BlockedAt)
+		if err != nil {
+			utils.WriteErrorJsonResponse(w, err.Error())
+			return
+		}
+		blockedUser.User.FIRID = firId.String
+		blockedUsers = append(blockedUsers, blockedUser)
 	}
+	utils.WriteStructs(w, blockedUsers)
 }
@@ -0,0 +1,66 @@
+package routes
+
+import (
+	"groupbuying.online/api/identity"
+	"groupbuying.online/api/structs"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func useFakeIdentityProviders() {
+	identityProviders = identity.New(&structs.Config{Identity: structs.IdentityConfig{Fake: true}})
+}
+
+func TestVerifyIdentityWithFakeProvider(t *testing.T) {
+	useFakeIdentityProviders()
+	tests := []struct {
+		provider string
+		token    string
+		status   int
+	}{
+		{"google", "fake|sub-1|alice@example.com", http.StatusOK},
+		{"email", "fake|fir-1|bob@example.com", http.StatusOK},
+		{"google", "invalid", http.StatusUnauthorized},
+		{"twitter", "fake|sub-1|alice@example.com", http.StatusUnauthorized},
+		// logins need an email to find or register the account
+		{"facebook", "fake|sub-1|", http.StatusUnauthorized},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/api/login/"+test.provider, nil)
+		claims, ok := verifyIdentity(w, r, test.provider, test.token)
+		if ok != (test.status == http.StatusOK) || w.Code != test.status {
+			t.Errorf("verifyIdentity(%s, %q) = %v with status %d, want %d", test.provider, test.token, ok, w.Code, test.status)
+		}
+		if ok && (claims.Provider != test.provider || claims.Subject == "") {
+			t.Errorf("verifyIdentity(%s, %q) claims = %+v", test.provider, test.token, claims)
+		}
+	}
+}
+
+// Registrations rejected before any user is inserted
+func TestRegisterBySocialMediaRejects(t *testing.T) {
+	useFakeIdentityProviders()
+	tests := []struct {
+		body   string
+		status int
+	}{
+		{`{"authType": "email", "userToken": "fake|sub-1|alice@example.com|alice"}`, http.StatusBadRequest},
+		{`{"authType": "google", "userToken": "invalid", "displayName": "alice"}`, http.StatusUnauthorized},
+		// the provider name has a space and none was given instead
+		{`{"authType": "google", "userToken": "fake|sub-1|alice@example.com|Alice Smith"}`, http.StatusBadRequest},
+		{`{"authType": "facebook", "userToken": "fake|sub-1|alice@example.com"}`, http.StatusBadRequest},
+		{`{"authType": "google", "userToken": "fake|sub-1|alice@example.com", "displayName": "` +
+			strings.Repeat("a", 43) + `"}`, http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/api/register/social_media", strings.NewReader(test.body))
+		registerBySocialMedia(w, r)
+		if w.Code != test.status {
+			t.Errorf("register %s = %d %s, want %d", test.body, w.Code, w.Body.String(), test.status)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package screening
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Rejects deals whose title is similar to, or description the same as, a deal the author posted within Window,
+// and holds comments the author already posted within Window.
+// Title similarity is pg_trgm similarity from 0 to 1.
+type DuplicateContent struct {
+	Db              *sql.DB
+	Window          time.Duration
+	TitleSimilarity float64
+}
+
+func (d DuplicateContent) Screen(content Content) (Result, error) {
+	since := time.Now().UTC().Add(-d.Window)
+	var duplicates int
+	var err error
+	switch content.Kind {
+	case "deal":
+		err = d.Db.QueryRow(`SELECT COUNT(*) FROM deals
+			WHERE poster_id = $1 AND posted_at > $2 AND inactive_at ISNULL
+			AND (similarity(title, $3) >= $4 OR description = $5)`,
+			content.AuthorID, since, content.Title, d.TitleSimilarity, content.Text).Scan(&duplicates)
+		if err == nil && duplicates > 0 {
+			return Result{Verdict: Reject, Reasons: []string{"duplicate of a recent deal"}}, nil
+		}
+	case "comment":
+		err = d.Db.QueryRow(`SELECT COUNT(*) FROM deal_comments
+			WHERE user_id = $1 AND posted_at > $2 AND removed_at ISNULL AND lower(comment_str) = lower($3)`,
+			content.AuthorID, since, content.Text).Scan(&duplicates)
+		if err == nil && duplicates > 0 {
+			return Result{Verdict: Hold, Reasons: []string{"duplicate of a recent comment"}}, nil
+		}
+	}
+	return Result{Verdict: Allow}, err
+}
+
+// Holds content of accounts younger than AccountAge that already posted MaxPerHour deals or comments in the last hour
+type NewAccountVelocity struct {
+	Db         *sql.DB
+	AccountAge time.Duration
+	MaxPerHour int
+}
+
+func (n NewAccountVelocity) Screen(content Content) (Result, error) {
+	now := time.Now().UTC()
+	var posts int
+	err := n.Db.QueryRow(`SELECT
+		(SELECT COUNT(*) FROM deals WHERE poster_id = u.id AND posted_at > $3)
+		+ (SELECT COUNT(*) FROM deal_comments WHERE user_id = u.id AND posted_at > $3)
+		FROM users u WHERE u.id = $1 AND u.created_at > $2`,
+		content.AuthorID, now.Add(-n.AccountAge), now.Add(-time.Hour)).Scan(&posts)
+	if err == sql.ErrNoRows {
+		return Result{Verdict: Allow}, nil
+	}
+	if err != nil || posts < n.MaxPerHour {
+		return Result{Verdict: Allow}, err
+	}
+	return Result{Verdict: Hold, Reasons: []string{
+		fmt.Sprintf("new account posted %d times in the last hour", posts)}}, nil
+}
@@ -0,0 +1,93 @@
+// Package screening checks submitted deals and comments for spam and abuse before they are inserted.
+package screening
+
+import (
+	"database/sql"
+	"groupbuying.online/api/structs"
+	"time"
+)
+
+// Verdicts from least to most severe
+type Verdict int
+
+const (
+	Allow Verdict = iota
+	Hold          // inserted but hidden until a moderator approves it
+	Reject
+)
+
+func (v Verdict) String() string {
+	switch v {
+	case Hold:
+		return "hold"
+	case Reject:
+		return "reject"
+	default:
+		return "allow"
+	}
+}
+
+type Content struct {
+	Kind     string // "deal" or "comment"
+	AuthorID string
+	Title    string // empty for comments
+	Text     string
+}
+
+type Result struct {
+	Verdict Verdict
+	Reasons []string
+}
+
+// Screener checks content before it is inserted, reasons are shown to moderators and the author
+type Screener interface {
+	Screen(content Content) (Result, error)
+}
+
+// Pipeline runs its screeners in order and returns the most severe verdict with the reasons of all
+// screeners that did not allow the content, stopping at the first rejection.
+type Pipeline []Screener
+
+func (p Pipeline) Screen(content Content) (Result, error) {
+	result := Result{Verdict: Allow}
+	for _, screener := range p {
+		screened, err := screener.Screen(content)
+		if err != nil {
+			return result, err
+		}
+		if screened.Verdict > result.Verdict {
+			result.Verdict = screened.Verdict
+		}
+		result.Reasons = append(result.Reasons, screened.Reasons...)
+		if result.Verdict == Reject {
+			break
+		}
+	}
+	return result, nil
+}
+
+// Pipeline of the screeners enabled in conf
+func New(conf structs.ScreeningConfig, db *sql.DB) Pipeline {
+	var pipeline Pipeline
+	if len(conf.BannedWords) > 0 {
+		pipeline = append(pipeline, NewBannedWords(conf.BannedWords))
+	}
+	if conf.MaxLinks > 0 {
+		pipeline = append(pipeline, LinkLimit{Max: conf.MaxLinks})
+	}
+	if conf.DuplicateWindowHours > 0 {
+		pipeline = append(pipeline, DuplicateContent{
+			Db:              db,
+			Window:          time.Duration(conf.DuplicateWindowHours) * time.Hour,
+			TitleSimilarity: conf.DuplicateTitleSimilarity,
+		})
+	}
+	if conf.NewAccountHours > 0 && conf.NewAccountMaxPostsPerHour > 0 {
+		pipeline = append(pipeline, NewAccountVelocity{
+			Db:         db,
+			AccountAge: time.Duration(conf.NewAccountHours) * time.Hour,
+			MaxPerHour: conf.NewAccountMaxPostsPerHour,
+		})
+	}
+	return pipeline
+}
@@ -0,0 +1,52 @@
+package screening
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var linkRegexp = regexp.MustCompile(`(?i)\b(https?://|www\.)\S+`)
+
+// Rejects content containing any of the words, case insensitive and on word boundaries
+type BannedWords struct {
+	pattern *regexp.Regexp
+}
+
+func NewBannedWords(words []string) BannedWords {
+	quoted := make([]string, len(words))
+	for i, word := range words {
+		quoted[i] = regexp.QuoteMeta(strings.ToLower(word))
+	}
+	return BannedWords{pattern: regexp.MustCompile(`(?i)\b(` + strings.Join(quoted, "|") + `)\b`)}
+}
+
+func (b BannedWords) Screen(content Content) (Result, error) {
+	matches := b.pattern.FindAllString(content.Title+"\n"+content.Text, -1)
+	if len(matches) == 0 {
+		return Result{Verdict: Allow}, nil
+	}
+	seen := make(map[string]bool)
+	var reasons []string
+	for _, match := range matches {
+		word := strings.ToLower(match)
+		if !seen[word] {
+			seen[word] = true
+			reasons = append(reasons, fmt.Sprintf("banned word '%s'", word))
+		}
+	}
+	return Result{Verdict: Reject, Reasons: reasons}, nil
+}
+
+// Holds content with more than Max links
+type LinkLimit struct {
+	Max int
+}
+
+func (l LinkLimit) Screen(content Content) (Result, error) {
+	links := len(linkRegexp.FindAllString(content.Title+"\n"+content.Text, -1))
+	if links <= l.Max {
+		return Result{Verdict: Allow}, nil
+	}
+	return Result{Verdict: Hold, Reasons: []string{fmt.Sprintf("%d links, at most %d allowed", links, l.Max)}}, nil
+}
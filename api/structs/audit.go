@@ -0,0 +1,21 @@
+package structs
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Maps to audit_log table, Before and After are snapshots of the target row
+type AuditLogEntry struct {
+	ID			string			`json:"id",db:"id"`
+	ActorID		*string			`json:"actorId",db:"actor_id"`
+	Action		string			`json:"action",db:"action"`
+	TargetType	*string			`json:"targetType",db:"target_type"`
+	TargetID	*string			`json:"targetId",db:"target_id"`
+	Before		json.RawMessage	`json:"before,omitempty",db:"before"`
+	After		json.RawMessage	`json:"after,omitempty",db:"after"`
+	Details		json.RawMessage	`json:"details,omitempty",db:"details"`
+	IP			*string			`json:"ip",db:"ip"`
+	UserAgent	*string			`json:"userAgent",db:"user_agent"`
+	CreatedAt	time.Time		`json:"createdAt",db:"created_at"`
+}
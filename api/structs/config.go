@@ -10,13 +10,85 @@ type Config struct {
 	DBPassword 		string		`json:"dbPassword"`
 	SessionStoreKey string		`json:"sessionStoreKey"`
 	SessionName	 	string		`json:"sessionName"`
+	// Sessions unused for this many days are logged out, defaults to 30
+	SessionIdleDays	int			`json:"sessionIdleDays"`
 	CSRFKey			string 		`json:"csrfKey"`
+	// Signs bearer access tokens, see package auth
+	JWTKey			string		`json:"jwtKey"`
+	AccessTokenMinutes	int		`json:"accessTokenMinutes"`
+	RefreshTokenDays	int		`json:"refreshTokenDays"`
+	// Issuer shown in authenticator apps for TOTP two factor auth
+	TOTPIssuer		string		`json:"totpIssuer"`
+	// Days between display name changes, 0 allows changing it anytime
+	DisplayNameCooldownDays	int	`json:"displayNameCooldownDays"`
+	// Proxies in front of the app appending to X-Forwarded-For, 0 uses the connection's address as the client ip
+	TrustedProxies	int			`json:"trustedProxies"`
+	// Shared secret for triggering /cron endpoints outside of App Engine
+	CronKey			string		`json:"cronKey"`
+	// Deals and comments are hidden once this many distinct users report them, 0 disables
+	ReportAutoHideThreshold int	`json:"reportAutoHideThreshold"`
+	// Days to keep audit_log entries, 0 keeps them forever
+	AuditLogRetentionDays	int	`json:"auditLogRetentionDays"`
+	// Checks on deals and comments before insert, see package screening
+	Screening		ScreeningConfig	`json:"screening"`
+	// Budgets by route group: login, register, postDeal, comment, chatNotification
+	RateLimits		map[string]RateLimit	`json:"rateLimits"`
 
 	FBAppId			string 		`json:"fbAppId"`
 	FBAppSecret		string 		`json:"fbAppSecret"`
+	// Sign in token verification, see package identity
+	Identity		IdentityConfig	`json:"identity"`
+	// Verification and password reset emails, see package mail
+	Mail			MailConfig		`json:"mail"`
 }
 
 
+// Each check is disabled when its value is 0 or empty
+type ScreeningConfig struct {
+	// Content with any of the words is rejected
+	BannedWords					[]string	`json:"bannedWords"`
+	// Content with more links is held for review
+	MaxLinks					int			`json:"maxLinks"`
+	// Deals similar to the poster's own within the window are rejected, similarity from 0 to 1
+	DuplicateWindowHours		int			`json:"duplicateWindowHours"`
+	DuplicateTitleSimilarity	float64		`json:"duplicateTitleSimilarity"`
+	// Accounts younger than this posting more per hour are held for review
+	NewAccountHours				int			`json:"newAccountHours"`
+	NewAccountMaxPostsPerHour	int			`json:"newAccountMaxPostsPerHour"`
+}
+
+// Endpoints of sign in providers, empty ones use the public endpoints
+type IdentityConfig struct {
+	// Verify every provider's tokens with identity.FakeProvider, for local dev without network
+	Fake					bool		`json:"fake"`
+	GoogleTokenInfoURL		string		`json:"googleTokenInfoUrl"`
+	// Google ID tokens must be issued for one of these client ids, any if empty
+	GoogleClientIDs			[]string	`json:"googleClientIds"`
+	FacebookGraphURL		string		`json:"facebookGraphUrl"`
+	FirebaseAuthURL			string		`json:"firebaseAuthUrl"`
+	// Web API key of the Firebase project, for email and password sign in
+	FirebaseAPIKey			string		`json:"firebaseApiKey"`
+}
+
+// Without smtpHost mails are written to files in logDir, or to the log if it is empty too
+type MailConfig struct {
+	From					string		`json:"from"`
+	SMTPHost				string		`json:"smtpHost"`
+	// Defaults to 587
+	SMTPPort				int			`json:"smtpPort"`
+	SMTPUsername			string		`json:"smtpUsername"`
+	SMTPPassword			string		`json:"smtpPassword"`
+	LogDir					string		`json:"logDir"`
+	// Site that links in mails go to, e.g. https://groupbuying.online for /verify_email?token=...
+	LinkBaseURL				string		`json:"linkBaseUrl"`
+}
+
+// Token bucket of Burst requests, refilled at PerMinute
+type RateLimit struct {
+	Burst		int			`json:"burst"`
+	PerMinute	float64		`json:"perMinute"`
+}
+
 type CloudSQLConfig struct {
 	Username, Password, Instance string
 }
\ No newline at end of file
@@ -30,8 +30,15 @@ type Deal struct {
 	CountryCode		*string		`json:"countryCode",db:"country_code"`
 	FeaturedUrl		*string		`json:"featuredUrl,omitEmpty",db:"featured_url"`
 	// derived columns
-	Likes			*uint		`json:"likes,omitEmpty"`
+	// net votes, upvotes minus downvotes
+	Likes			*int		`json:"likes,omitEmpty"`
 	Members 		*uint		`json:"members,omitEmpty"`
+	Comments		*uint		`json:"comments,omitempty",db:"comments_count"`
+	Images			*uint		`json:"images,omitempty",db:"images_count"`
+	HotScore		*float64	`json:"hotScore,omitempty",db:"hot_score"`
+	Votes			*DealVoteSummary	`json:"votes,omitempty"`
+	// session user's favorite, omitted if not logged in
+	IsFavorited		*bool		`json:"isFavorited,omitempty"`
 }
 
 type DealCategory struct {
@@ -63,6 +70,14 @@ type DealLikes struct {
 	IsUpVote	bool		`json:"isUpvote",db:"is_upvote"`
 }
 
+type DealVoteSummary struct {
+	Upvotes		uint		`json:"upvotes"`
+	Downvotes	uint		`json:"downvotes"`
+	Net			int			`json:"net"`
+	// session user's vote, null if not voted or not logged in
+	MyVote		*bool		`json:"myVote"`
+}
+
 type DealComment struct {
 	ID			string 		`json:"id",db:"id"`
 	Username	string 		`json:"username",db:"username"`
@@ -71,4 +86,36 @@ type DealComment struct {
 	Comment		string		`json:"comment",db:"comment"`
 	PostedAt	time.Time	`json:"postedAt",db:"posted_at"`
 	UserFIRID	*string 	`json:"userFirId,omitEmpty"`
+	ParentID	*string		`json:"parentId,omitempty",db:"parent_id"`
+	EditedAt	*time.Time	`json:"editedAt,omitempty",db:"edited_at"`
+	IsEdited	bool		`json:"isEdited"`
+	// moderated by the deal poster
+	IsHidden	bool		`json:"isHidden,omitempty"`
+	IsPinned	bool		`json:"isPinned"`
+	IsLocked	bool		`json:"isLocked"`
+	Reactions	DealCommentReactions	`json:"reactions"`
+	// only in threaded listing
+	Replies		[]DealComment	`json:"replies,omitempty"`
+}
+
+type DealCommentReactions struct {
+	// count by reaction name, e.g. {"like": 2}
+	Counts		map[string]uint	`json:"counts"`
+	// session user's reactions
+	Mine		[]string		`json:"mine"`
+}
+
+// Open reports of a comment, for the deal poster's moderation queue
+type DealCommentReport struct {
+	Comment			DealComment	`json:"comment"`
+	Reports			uint		`json:"reports"`
+	Reasons			[]string	`json:"reasons"`
+	LastReportedAt	time.Time	`json:"lastReportedAt"`
+}
+
+// Previous text of an edited comment
+type DealCommentEdit struct {
+	Comment		string		`json:"comment",db:"comment_str"`
+	PostedAt	time.Time	`json:"postedAt",db:"posted_at"`
+	EditedAt	time.Time	`json:"editedAt",db:"edited_at"`
 }
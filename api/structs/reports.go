@@ -0,0 +1,30 @@
+package structs
+
+import "time"
+
+// Open reports of a deal, comment or user, for the admin moderation queue
+type ReportedTarget struct {
+	TargetType		string		`json:"targetType"`
+	TargetID		string		`json:"targetId"`
+	Reports			uint		`json:"reports"`
+	Reporters		uint		`json:"reporters"`
+	ReasonCodes		[]string	`json:"reasonCodes"`
+	Reasons			[]string	`json:"reasons"`
+	FirstReportedAt	time.Time	`json:"firstReportedAt"`
+	LastReportedAt	time.Time	`json:"lastReportedAt"`
+	IsHidden		bool		`json:"isHidden"`
+}
+
+// Maps to content_screenings table, TargetID is nil for rejected content
+type ContentScreening struct {
+	ID			string		`json:"id",db:"id"`
+	AuthorID	string		`json:"authorId",db:"author_id"`
+	TargetType	string		`json:"targetType",db:"target_type"`
+	TargetID	*string		`json:"targetId",db:"target_id"`
+	Verdict		string		`json:"verdict",db:"verdict"`
+	Reasons		[]string	`json:"reasons",db:"reasons"`
+	Content		string		`json:"content",db:"content"`
+	CreatedAt	time.Time	`json:"createdAt",db:"created_at"`
+	ReviewedAt	*time.Time	`json:"reviewedAt,omitempty",db:"reviewed_at"`
+	Resolution	*string		`json:"resolution,omitempty",db:"resolution"`
+}
@@ -11,6 +11,7 @@ type Suggestion struct {
 	Longitude		*float64	`json:"longitude,omitempty",db:"longitude"`
 	RadiusKm		*float64	`json:"radiusKm,omitempty",db:"radius_km"`
 	BannerUrl 		*string 	`json:"bannerUrl,omitempty",db:"banner_url"`
-	InactiveBy		*time.Time  `db:"inactive_by"`
+	ActiveFrom		*time.Time  `json:"activeFrom,omitempty",db:"active_from"`
+	InactiveBy		*time.Time  `json:"inactiveBy,omitempty",db:"inactive_by"`
 }
 
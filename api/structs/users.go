@@ -1,14 +1,42 @@
 package structs
 
+import "time"
+
 // Maps to Users table
 type User struct {
 	ID				string 		`json:"id",db:"id"`
 	DisplayName 	string		`json:"displayName",db:"display_name"`
 	ImageURL		*string 	`json:"imageUrl,omitempty",db:"image_url"`
 	CountryCode		string 		`json:"countryCode",db:"country_code"`
+	Bio				*string		`json:"bio,omitempty",db:"bio"`
 	AuthType		*string 	`json:"authType,omitEmpty",db:"auth_type"`
 	Email			*string 	`json:"email,omitEmpty",db:"email"`
 	FIRID			string		`json:"firId",db:"fir_id"`
+	Role			*string		`json:"role,omitempty",db:"role"`
+	// Only in login responses
+	Tokens			*AuthTokens	`json:"tokens,omitempty"`
+}
+
+// Bearer credentials for clients without cookies, the refresh token is exchanged at /api/token/refresh
+type AuthTokens struct {
+	AccessToken				string		`json:"accessToken"`
+	AccessTokenExpiresAt	time.Time	`json:"accessTokenExpiresAt"`
+	RefreshToken			string		`json:"refreshToken"`
+	RefreshTokenExpiresAt	time.Time	`json:"refreshTokenExpiresAt"`
+}
+
+type BlockedUser struct {
+	User		User		`json:"user"`
+	BlockedAt	time.Time	`json:"blockedAt"`
+}
+
+type BannedUser struct {
+	User		User		`json:"user"`
+	Kind		string		`json:"kind"`
+	Reason		*string		`json:"reason,omitempty"`
+	ExpiresAt	*time.Time	`json:"expiresAt,omitempty"`
+	BannedBy	*string		`json:"bannedBy,omitempty"`
+	BannedAt	time.Time	`json:"bannedAt"`
 }
 
 // Temp struct For marshalling login / register requests
@@ -20,9 +48,10 @@ type UserCredentials struct {
 	CountryCode	string 	`json:"countryCode"`
 }
 
+// The email is taken from the verified claims of UserToken, display name and image default to them
 type UserCredentialSocialMedia struct {
 	FIRID 		string	`json:"firId"`
-	Email 		string	`json:"email"`
+	UserToken	string	`json:"userToken"`
 	DisplayName string	`json:"displayName"`
 	ImageUrl 	string  `json:"imageUrl"`
 	AuthType	string  `json:"authType"`
@@ -34,3 +63,40 @@ type SocialSignInCredentials struct {
 	UserToken 	string 	`json:"userToken"`
 	Email 		string	`json:"email"`
 }
+
+// Maps to user_sessions table, a logged in cookie session of a device,
+// or to a family of refresh_tokens of a bearer client
+type UserSession struct {
+	ID				string		`json:"id",db:"id"`
+	UserID			string		`json:"-",db:"user_id"`
+	Device			string		`json:"device",db:"device"`
+	UserAgent		string		`json:"userAgent",db:"user_agent"`
+	IP				string		`json:"ip",db:"ip"`
+	CreatedAt		time.Time	`json:"createdAt",db:"created_at"`
+	LastSeenAt		time.Time	`json:"lastSeenAt",db:"last_seen_at"`
+	// Whether it is the session of the request listing sessions
+	IsCurrent		bool		`json:"isCurrent"`
+	// Whether it is a bearer client, its id being the refresh token family
+	Bearer			bool		`json:"bearer"`
+}
+
+// Maps to user_identities table, a sign in method of a user
+type UserIdentity struct {
+	Provider		string		`json:"provider",db:"provider"`
+	Email			*string		`json:"email",db:"email"`
+	LinkedAt		time.Time	`json:"linkedAt",db:"linked_at"`
+}
+
+// Login response instead of the user when they have two factor auth,
+// the token is sent with their code to /api/login/totp
+type MFAChallenge struct {
+	MFARequired		bool		`json:"mfaRequired"`
+	MFAToken		string		`json:"mfaToken"`
+	ExpiresAt		time.Time	`json:"expiresAt"`
+}
+
+// Secret to add to an authenticator app, and its otpauth:// URI to show as a QR code
+type TOTPEnrollment struct {
+	Secret			string		`json:"secret"`
+	URI				string		`json:"uri"`
+}
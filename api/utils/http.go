@@ -2,7 +2,9 @@ package utils
 
 import (
 	"encoding/json"
+	"net"
 	"net/http"
+	"strings"
 )
 
 func WriteBytes(w http.ResponseWriter, message []byte) {
@@ -18,4 +20,20 @@ func WriteStructs(w http.ResponseWriter, instance interface{}) {
 	instanceBytes, err := json.Marshal(instance)
 	CheckFatalError(w, err)
 	WriteBytes(w, instanceBytes)
-}
\ No newline at end of file
+}
+// Client address of the request behind trustedProxies proxies that each append to X-Forwarded-For,
+// i.e. the address the outermost one appended. Earlier entries are sent by the client and can be forged.
+// The connection's address when not behind a proxy or the header has fewer entries.
+func GetClientIP(r *http.Request, trustedProxies int) string {
+	if forwarded := r.Header.Get("X-Forwarded-For"); trustedProxies > 0 && forwarded != "" {
+		hops := strings.Split(forwarded, ",")
+		if len(hops) >= trustedProxies {
+			return strings.TrimSpace(hops[len(hops)-trustedProxies])
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,32 @@
+package utils
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestGetClientIP(t *testing.T) {
+	tests := []struct {
+		forwarded      string
+		trustedProxies int
+		want           string
+	}{
+		{"", 0, "10.0.0.1"},
+		{"1.1.1.1", 0, "10.0.0.1"},
+		{"1.1.1.1", 1, "1.1.1.1"},
+		// the client sent the first entry, the proxy appended its address
+		{"6.6.6.6, 1.1.1.1", 1, "1.1.1.1"},
+		{"6.6.6.6, 1.1.1.1, 2.2.2.2", 2, "1.1.1.1"},
+		{"1.1.1.1", 2, "10.0.0.1"},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = "10.0.0.1:1234"
+		if test.forwarded != "" {
+			r.Header.Set("X-Forwarded-For", test.forwarded)
+		}
+		if got := GetClientIP(r, test.trustedProxies); got != test.want {
+			t.Errorf("GetClientIP(%q, %d) = %s, want %s", test.forwarded, test.trustedProxies, got, test.want)
+		}
+	}
+}
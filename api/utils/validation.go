@@ -3,7 +3,9 @@ package utils
 import (
 	"fmt"
 	"github.com/google/uuid"
+	"groupbuying.online/api/auth"
 	"groupbuying.online/api/env"
+	"groupbuying.online/api/structs"
 	"log"
 	"net/http"
 	"unicode"
@@ -33,7 +35,7 @@ func IsValidUsername(s string) error {
 }
 
 func IsValidOrderByColumn(s string) bool {
-	reqCols := []string{"posted_at", "total_price", "likes", "members"}
+	reqCols := []string{"posted_at", "total_price", "likes", "members", "hot", "best"}
 	for _, reqCol := range reqCols {
 		if s == reqCol {
 			return true
@@ -46,11 +48,24 @@ func IsValidOrderDirection(s string) bool {
 	return s == "DESC" || s == "ASC"
 }
 
+// User id of the request from the auth middleware's context, its bearer token or its cookie session
 func GetUserIdInSession(r *http.Request) (string, bool) {
-	session, _ := env.Store.Get(r, env.Conf.SessionName)
-	userId, ok := session.Values["userId"].(string)
-	if !IsValidUUID(userId) {
+	if userId, found, err := auth.UserIdFromRequest(r, env.Conf.JWTKey); found {
+		return userId, err == nil && IsValidUUID(userId)
+	}
+	session, err := GetCookieSession(r)
+	if err != nil || !IsValidUUID(session.UserID) {
 		return "", false
 	}
-	return userId, ok
+	return session.UserID, true
+}
+
+// Active server side session of the request's cookie, auth.ErrSessionNotFound if it has none
+func GetCookieSession(r *http.Request) (structs.UserSession, error) {
+	cookieSession, _ := env.Store.Get(r, env.Conf.SessionName)
+	token, _ := cookieSession.Values[auth.CookieSessionKey].(string)
+	if token == "" {
+		return structs.UserSession{}, auth.ErrSessionNotFound
+	}
+	return env.Sessions.Get(token)
 }
@@ -1,4 +1,4 @@
-DROP TABLE IF EXISTS users, users_blocked, users_reported, users_banned CASCADE;
+DROP TABLE IF EXISTS users, user_identities, users_blocked, users_reported, users_banned CASCADE;
 
 CREATE EXTENSION IF NOT EXISTS "uuid-ossp";
 CREATE EXTENSION IF NOT EXISTS "citext";
@@ -8,17 +8,43 @@ CREATE TABLE users
   -- uuid for dynamic tables for easier sharding
   id                    uuid primary key default uuid_generate_v4(),
   email                 citext not null unique,
-  display_name          text not null,
+  display_name          text not null,        -- unique ignoring case
+  display_name_changed_at timestamp,          -- last change after registering, see displayNameCooldownDays in config
+  bio                   text,
   image_url             text,
   country_code          char(2),
-  auth_type             text,
+  auth_type             text,                 -- sign in method registered with, see user_identities for all of them
+  password_hash         text,                 -- bcrypt, null for users without a password
+  email_verified_at     timestamp,
+  totp_secret           text,                 -- base32, set on enrollment and only used once totp_enabled_at is set
+  totp_enabled_at       timestamp,
+  totp_last_step        bigint,               -- time step of the last accepted code, codes can't be reused
   fir_id                text,
+  role                  text not null default 'user', -- see roleRanks in middleware/middleware.go
   created_at            timestamp default timezone('utc', now()),
+  CHECK (role IN ('user', 'moderator', 'admin')),
   CHECK (length(display_name) <= 42),
-  CHECK (length(image_url) <= 256)
+  CHECK (length(image_url) <= 256),
+  CHECK (length(bio) <= 280)
 );
 
 CREATE UNIQUE INDEX users_unique_lower_email_idx ON users (lower(email));
+CREATE UNIQUE INDEX users_unique_lower_display_name_idx ON users (lower(display_name));
+
+-- Sign in methods of a user, logins resolve the user by the provider and subject of the verified token.
+-- Existing databases are migrated by sql/migrations/1_user_identities.sql
+CREATE TABLE user_identities
+(
+  id            SERIAL primary key,
+  user_id       uuid not null references users(id) ON DELETE CASCADE,
+  provider      text not null,                -- "email" for Firebase email sign in, "google" or "facebook"
+  subject       text,                         -- user id at the provider, null if migrated without it until the next login
+  email         citext,                       -- email at the provider, may differ from users.email
+  linked_at     timestamp default timezone('utc', now()),
+  UNIQUE (provider, subject),
+  UNIQUE (user_id, provider),
+  CHECK (provider IN ('email', 'google', 'facebook'))
+);
 
 CREATE TABLE users_blocked
 (
@@ -29,17 +55,25 @@ CREATE TABLE users_blocked
   UNIQUE (user_id, blocked_id)
 );
 
-CREATE TABLE users_reported
-(
-  id            SERIAL primary key,
-  reporter_id   uuid references users(id),
-  reported_id   uuid references users(id),
-  reason        text,
-  created_at    timestamp default timezone('utc', now())
-);
+-- reports of users are in content_reports, see 1_deals.sql
 
+-- ban: no login, suspension: no posting, shadow: content only visible to the user
 CREATE TABLE users_banned
 (
-  user_id       uuid primary key references users(id),
-  created_at    timestamp default timezone('utc', now())
+  user_id       uuid references users(id),
+  kind          text not null default 'ban',
+  reason        text,
+  expires_at    timestamp,                    -- permanent if null
+  banned_by     uuid references users(id),
+  created_at    timestamp default timezone('utc', now()),
+  primary key (user_id, kind),
+  CHECK (kind IN ('ban', 'suspension', 'shadow')),
+  CHECK (length(reason) <= 256)
 );
+
+-- Whether the user has an unexpired sanction of kind in users_banned
+CREATE OR REPLACE FUNCTION is_user_sanctioned(uid uuid, sanction text) RETURNS boolean AS $$
+  SELECT EXISTS (SELECT 1 FROM users_banned
+    WHERE user_id = uid AND kind = sanction
+    AND (expires_at IS NULL OR expires_at > timezone('utc', now())))
+$$ LANGUAGE sql STABLE;
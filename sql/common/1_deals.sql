@@ -4,7 +4,9 @@ ALTER TABLE IF EXISTS deals
   DROP CONSTRAINT IF EXISTS deals_category_id_fkey,
   DROP CONSTRAINT IF EXISTS deals_poster_id_fkey;
 DROP TABLE IF EXISTS
-  deals, deal_categories, deal_likes, deal_memberships, deal_images, deal_comments, deal_hidden
+  deals, deal_categories, deal_likes, deal_memberships, deal_images, deal_comments, deal_hidden,
+  deal_favorites, deal_comment_edits, deal_comment_mentions, content_reports,
+  deal_comment_reactions, content_screenings
   CASCADE;
 
 CREATE EXTENSION IF NOT EXISTS "uuid-ossp";  -- uuid
@@ -34,6 +36,17 @@ CREATE TABLE deals
   is_featured       boolean default false,
   featured_url      text,
   country_code      char(2),
+  hot_score         float not null default 0,     -- time-decayed engagement, see routes/ranking.go
+  hot_score_at      timestamp,
+  -- counters maintained by triggers in 3_deal_counters.sql
+  likes_count       int not null default 0,
+  downvotes_count   int not null default 0,
+  members_count     int not null default 0,
+  comments_count    int not null default 0,
+  images_count      int not null default 0,
+  near_full_notified_at timestamp,              -- favorites are told once when members near quantity
+  moderated_at      timestamp,                  -- hidden from everyone by reports or an admin
+  held_at           timestamp,                  -- by screening until approved, only shown to the poster
   CHECK (length(title) <= 128),
   CHECK (length(benefits) <= 128),
   CHECK (length(description) <= 512),
@@ -89,12 +102,101 @@ CREATE TABLE deal_comments
   id          uuid primary key default uuid_generate_v4(),
   deal_id     uuid references deals(id),
   user_id     uuid references users(id),
+  parent_id   uuid references deal_comments(id), -- reply to
   comment_str text not null,
   posted_at   timestamp default timezone('utc', now()),
-  removed_at  timestamp,
+  edited_at   timestamp,
+  removed_at  timestamp,                    -- by author
+  -- by deal poster
+  hidden_at   timestamp,
+  pinned_at   timestamp,
+  locked_at   timestamp,                    -- no edits or replies
+  moderated_at timestamp,                   -- by reports or an admin, hidden from everyone
+  held_at     timestamp,                    -- by screening until approved, only shown to the author
   CHECK (length(comment_str) <= 256)
 );
 
+CREATE INDEX deal_comments_parent_id_idx ON deal_comments (parent_id);
+
+-- previous versions of edited comments, posted_at is when that version was written
+CREATE TABLE deal_comment_edits
+(
+  id          uuid primary key default uuid_generate_v4(),
+  comment_id  uuid references deal_comments(id),
+  comment_str text not null,
+  posted_at   timestamp,
+  edited_at   timestamp default timezone('utc', now())
+);
+
+CREATE INDEX deal_comment_edits_comment_id_idx ON deal_comment_edits (comment_id);
+
+CREATE TABLE deal_comment_mentions
+(
+  id          uuid primary key default uuid_generate_v4(),
+  comment_id  uuid references deal_comments(id),
+  user_id     uuid references users(id),
+  UNIQUE(comment_id, user_id)
+);
+
+-- names of reactions are mapped to emoji by clients, keep in sync with commentReactions in routes/comments.go
+CREATE TABLE deal_comment_reactions
+(
+  id          uuid primary key default uuid_generate_v4(),
+  comment_id  uuid references deal_comments(id),
+  user_id     uuid references users(id),
+  reaction    text not null,
+  created_at  timestamp default timezone('utc', now()),
+  CHECK (reaction IN ('like', 'love', 'laugh', 'wow', 'sad', 'angry')),
+  UNIQUE(comment_id, user_id, reaction)
+);
+
+-- reports of user content, target_id refers to the table of target_type
+CREATE TABLE content_reports
+(
+  id            uuid primary key default uuid_generate_v4(),
+  reporter_id   uuid references users(id),
+  target_type   text not null,
+  target_id     uuid not null,
+  reason_code   text not null default 'other', -- keep in sync with reportReasonCodes in routes/reports.go
+  reason        text,
+  created_at    timestamp default timezone('utc', now()),
+  resolved_at   timestamp,
+  resolved_by   uuid references users(id),
+  resolution    text,
+  resolution_note text,
+  CHECK (target_type IN ('deal', 'comment', 'user')),
+  CHECK (reason_code IN ('spam', 'scam', 'offensive', 'harassment', 'prohibited', 'other')),
+  CHECK (resolution IN ('dismissed', 'hidden', 'banned')),
+  CHECK (length(reason) <= 256)
+);
+
+CREATE INDEX content_reports_target_idx ON content_reports (target_type, target_id) WHERE resolved_at IS NULL;
+-- one open report of a target per reporter, they can report it again once resolved
+CREATE UNIQUE INDEX content_reports_open_reporter_idx ON content_reports (reporter_id, target_type, target_id)
+  WHERE resolved_at IS NULL;
+
+-- deals and comments held or rejected by screening before insert, see package screening.
+-- Held content is inserted with held_at set, rejected content has no target_id.
+CREATE TABLE content_screenings
+(
+  id            uuid primary key default uuid_generate_v4(),
+  author_id     uuid references users(id),
+  target_type   text not null,
+  target_id     uuid,
+  verdict       text not null,
+  reasons       text[] not null,
+  content       text,
+  created_at    timestamp default timezone('utc', now()),
+  reviewed_at   timestamp,
+  reviewed_by   uuid references users(id),
+  resolution    text,
+  CHECK (target_type IN ('deal', 'comment')),
+  CHECK (verdict IN ('hold', 'reject')),
+  CHECK (resolution IN ('approved', 'rejected'))
+);
+
+CREATE INDEX content_screenings_pending_idx ON content_screenings (created_at) WHERE reviewed_at IS NULL;
+
 CREATE TABLE deal_hidden
 (
   id      uuid primary key default uuid_generate_v4(),
@@ -103,11 +205,35 @@ CREATE TABLE deal_hidden
   UNIQUE(user_id, deal_id)
 );
 
+-- watchlist, separate from votes in deal_likes
+CREATE TABLE deal_favorites
+(
+  id          uuid primary key default uuid_generate_v4(),
+  user_id     uuid references users(id),
+  deal_id     uuid references deals(id),
+  created_at  timestamp default timezone('utc', now()),
+  UNIQUE(user_id, deal_id)
+);
+
+CREATE INDEX deal_favorites_deal_id_idx ON deal_favorites (deal_id);
+
 ALTER TABLE deals
   ADD CONSTRAINT deals_thumbnail_id_fkey FOREIGN KEY (thumbnail_id) REFERENCES deal_images(id) ON DELETE CASCADE,
   ADD CONSTRAINT deals_category_id_fkey FOREIGN KEY (category_id) REFERENCES deal_categories(id) ON DELETE CASCADE,
   ADD CONSTRAINT deals_poster_id_fkey FOREIGN KEY (poster_id) REFERENCES users(id) ON DELETE CASCADE;
 
+CREATE INDEX deals_hot_score_idx ON deals (hot_score DESC) WHERE inactive_at IS NULL;
+CREATE INDEX deals_title_trgm_idx ON deals USING gin (title gin_trgm_ops);
+
+-- Lower bound of Wilson score interval at 95% confidence for the upvote ratio, used by `best` sort
+CREATE OR REPLACE FUNCTION wilson_lower_bound(upvotes bigint, downvotes bigint) RETURNS float AS $$
+  SELECT CASE WHEN upvotes + downvotes = 0 THEN 0 ELSE
+    ((upvotes + 1.9208) / (upvotes + downvotes)
+      - 1.96 * sqrt((upvotes * downvotes)::float / (upvotes + downvotes) + 0.9604) / (upvotes + downvotes))
+    / (1 + 3.8416 / (upvotes + downvotes))
+  END
+$$ LANGUAGE sql IMMUTABLE;
+
 
 -- FILL INITIAL TABLE
 BEGIN;
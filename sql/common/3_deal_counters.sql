@@ -0,0 +1,122 @@
+-- Keeps deals.*_count columns in step with deal_likes, deal_memberships, deal_comments and deal_images.
+-- Counters are updated by deltas so concurrent writes serialize on the deal row instead of racing recounts.
+-- If they ever drift, `SELECT repair_deal_counters();` recomputes them (see `make repair-counters`).
+
+CREATE OR REPLACE FUNCTION deal_likes_counters() RETURNS trigger AS $$
+DECLARE
+  vUp int := 0;
+  vDown int := 0;
+  vDealId uuid;
+BEGIN
+  IF TG_OP = 'UPDATE' OR TG_OP = 'DELETE' THEN
+    vDealId := OLD.deal_id;
+    IF OLD.is_upvote THEN
+      vUp := vUp - 1;
+    ELSIF NOT OLD.is_upvote THEN
+      vDown := vDown - 1;
+    END IF;
+  END IF;
+  IF TG_OP = 'INSERT' OR TG_OP = 'UPDATE' THEN
+    vDealId := NEW.deal_id;
+    IF NEW.is_upvote THEN
+      vUp := vUp + 1;
+    ELSIF NOT NEW.is_upvote THEN
+      vDown := vDown + 1;
+    END IF;
+  END IF;
+  IF vUp <> 0 OR vDown <> 0 THEN
+    UPDATE deals SET likes_count = likes_count + vUp, downvotes_count = downvotes_count + vDown
+    WHERE id = vDealId;
+  END IF;
+  RETURN NULL;
+END
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION deal_memberships_counters() RETURNS trigger AS $$
+BEGIN
+  IF TG_OP = 'INSERT' THEN
+    UPDATE deals SET members_count = members_count + 1 WHERE id = NEW.deal_id;
+  ELSIF TG_OP = 'DELETE' THEN
+    UPDATE deals SET members_count = members_count - 1 WHERE id = OLD.deal_id;
+  END IF;
+  RETURN NULL;
+END
+$$ LANGUAGE plpgsql;
+
+-- For tables soft deleted with removed_at, TG_ARGV[0] is the counter column on deals
+CREATE OR REPLACE FUNCTION deal_removable_counters() RETURNS trigger AS $$
+DECLARE
+  vDelta int := 0;
+  vDealId uuid;
+BEGIN
+  IF TG_OP = 'UPDATE' OR TG_OP = 'DELETE' THEN
+    vDealId := OLD.deal_id;
+    IF OLD.removed_at IS NULL THEN
+      vDelta := vDelta - 1;
+    END IF;
+  END IF;
+  IF TG_OP = 'INSERT' OR TG_OP = 'UPDATE' THEN
+    vDealId := NEW.deal_id;
+    IF NEW.removed_at IS NULL THEN
+      vDelta := vDelta + 1;
+    END IF;
+  END IF;
+  IF vDelta <> 0 THEN
+    EXECUTE format('UPDATE deals SET %I = %I + $1 WHERE id = $2', TG_ARGV[0], TG_ARGV[0])
+    USING vDelta, vDealId;
+  END IF;
+  RETURN NULL;
+END
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS deal_likes_counters_trigger ON deal_likes;
+CREATE TRIGGER deal_likes_counters_trigger
+  AFTER INSERT OR UPDATE OF is_upvote OR DELETE ON deal_likes
+  FOR EACH ROW EXECUTE PROCEDURE deal_likes_counters();
+
+DROP TRIGGER IF EXISTS deal_memberships_counters_trigger ON deal_memberships;
+CREATE TRIGGER deal_memberships_counters_trigger
+  AFTER INSERT OR DELETE ON deal_memberships
+  FOR EACH ROW EXECUTE PROCEDURE deal_memberships_counters();
+
+DROP TRIGGER IF EXISTS deal_comments_counters_trigger ON deal_comments;
+CREATE TRIGGER deal_comments_counters_trigger
+  AFTER INSERT OR UPDATE OF removed_at OR DELETE ON deal_comments
+  FOR EACH ROW EXECUTE PROCEDURE deal_removable_counters('comments_count');
+
+DROP TRIGGER IF EXISTS deal_images_counters_trigger ON deal_images;
+CREATE TRIGGER deal_images_counters_trigger
+  AFTER INSERT OR UPDATE OF removed_at OR DELETE ON deal_images
+  FOR EACH ROW EXECUTE PROCEDURE deal_removable_counters('images_count');
+
+-- Recompute all counters from source tables, returns number of deals that had drifted
+CREATE OR REPLACE FUNCTION repair_deal_counters() RETURNS int AS $$
+DECLARE
+  vRepaired int;
+BEGIN
+  UPDATE deals d SET
+    likes_count = c.likes_count,
+    downvotes_count = c.downvotes_count,
+    members_count = c.members_count,
+    comments_count = c.comments_count,
+    images_count = c.images_count
+  FROM (
+    SELECT d.id,
+      (SELECT COUNT(*) FROM deal_likes d_l WHERE d_l.deal_id = d.id AND d_l.is_upvote) AS likes_count,
+      (SELECT COUNT(*) FROM deal_likes d_l WHERE d_l.deal_id = d.id AND NOT d_l.is_upvote) AS downvotes_count,
+      (SELECT COUNT(*) FROM deal_memberships d_m WHERE d_m.deal_id = d.id) AS members_count,
+      (SELECT COUNT(*) FROM deal_comments d_c WHERE d_c.deal_id = d.id AND d_c.removed_at IS NULL) AS comments_count,
+      (SELECT COUNT(*) FROM deal_images d_i WHERE d_i.deal_id = d.id AND d_i.removed_at IS NULL) AS images_count
+    FROM deals d
+  ) c
+  WHERE d.id = c.id
+    AND (d.likes_count, d.downvotes_count, d.members_count, d.comments_count, d.images_count)
+      IS DISTINCT FROM (c.likes_count, c.downvotes_count, c.members_count, c.comments_count, c.images_count);
+  GET DIAGNOSTICS vRepaired = ROW_COUNT;
+  RETURN vRepaired;
+END
+$$ LANGUAGE plpgsql;
+
+CREATE INDEX IF NOT EXISTS deal_likes_deal_id_idx ON deal_likes (deal_id);
+CREATE INDEX IF NOT EXISTS deal_comments_deal_id_idx ON deal_comments (deal_id);
+CREATE INDEX IF NOT EXISTS deal_images_deal_id_idx ON deal_images (deal_id);
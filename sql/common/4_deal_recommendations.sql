@@ -0,0 +1,13 @@
+-- Precomputed "for you" feed, filled by /cron/deals/recommendations (see routes/recommendations.go)
+DROP TABLE IF EXISTS deal_recommendations CASCADE;
+
+CREATE TABLE deal_recommendations
+(
+  user_id       uuid references users(id) ON DELETE CASCADE,
+  deal_id       uuid references deals(id) ON DELETE CASCADE,
+  score         float not null,
+  computed_at   timestamp default timezone('utc', now()),
+  primary key (user_id, deal_id)
+);
+
+CREATE INDEX deal_recommendations_user_score_idx ON deal_recommendations (user_id, score DESC);
@@ -0,0 +1,34 @@
+DROP TABLE IF EXISTS audit_log CASCADE;
+
+-- Append-only log of security relevant and moderation actions, written by writeAuditLog in routes/audit.go
+CREATE TABLE audit_log
+(
+  id            uuid primary key default uuid_generate_v4(),
+  actor_id      uuid,          -- no foreign key, entries outlive their users
+  action        text not null,
+  target_type   text,
+  target_id     text,          -- uuid or serial id of the target
+  before        jsonb,         -- snapshot of the target row before and after the action
+  after         jsonb,
+  details       jsonb,
+  ip            text,
+  user_agent    text,
+  created_at    timestamp default timezone('utc', now())
+);
+
+CREATE INDEX audit_log_created_at_idx ON audit_log (created_at DESC);
+CREATE INDEX audit_log_actor_id_idx ON audit_log (actor_id, created_at DESC);
+CREATE INDEX audit_log_target_idx ON audit_log (target_type, target_id);
+
+-- Rows can't be changed, and only be deleted by the retention job which sets audit.retention
+CREATE OR REPLACE FUNCTION audit_log_append_only() RETURNS trigger AS $$
+BEGIN
+  IF TG_OP = 'DELETE' AND current_setting('audit.retention', true) = 'on' THEN
+    RETURN OLD;
+  END IF;
+  RAISE EXCEPTION 'audit_log is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER audit_log_append_only BEFORE UPDATE OR DELETE ON audit_log
+  FOR EACH ROW EXECUTE PROCEDURE audit_log_append_only();
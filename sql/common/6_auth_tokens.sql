@@ -0,0 +1,88 @@
+DROP TABLE IF EXISTS refresh_tokens CASCADE;
+
+-- Refresh tokens of bearer clients, see package auth. Each refresh replaces the token with a new one
+-- of the same family, reusing a replaced token revokes the family.
+CREATE TABLE refresh_tokens
+(
+  id            uuid primary key default uuid_generate_v4(),
+  user_id       uuid not null references users(id) ON DELETE CASCADE,
+  token_hash    text not null unique,         -- sha256 hex, the token itself is not stored
+  family_id     uuid not null,
+  -- client of the login or refresh issuing the token, listed with the user's sessions
+  device        text not null default '',
+  user_agent    text not null default '',
+  ip            text not null default '',
+  created_at    timestamp default timezone('utc', now()),
+  expires_at    timestamp not null,
+  used_at       timestamp,
+  revoked_at    timestamp
+);
+
+CREATE INDEX refresh_tokens_family_id_idx ON refresh_tokens (family_id);
+CREATE INDEX refresh_tokens_user_id_idx ON refresh_tokens (user_id) WHERE revoked_at IS NULL;
+
+DROP TABLE IF EXISTS user_sessions CASCADE;
+
+-- Server side cookie sessions, the cookie only holds the token, see auth.PostgresSessionStore.
+-- Revoked sessions are logged out on their next request.
+CREATE TABLE user_sessions
+(
+  id            uuid primary key default uuid_generate_v4(),
+  user_id       uuid not null references users(id) ON DELETE CASCADE,
+  token_hash    text not null unique,         -- sha256 hex, the token itself is not stored
+  device        text not null default '',
+  user_agent    text not null default '',
+  ip            text not null default '',
+  created_at    timestamp not null default timezone('utc', now()),
+  last_seen_at  timestamp not null default timezone('utc', now()),
+  revoked_at    timestamp
+);
+
+CREATE INDEX user_sessions_user_id_idx ON user_sessions (user_id) WHERE revoked_at IS NULL;
+
+DROP TABLE IF EXISTS user_email_tokens CASCADE;
+
+-- One time tokens sent by email to verify it or reset the password, see auth.IssueEmailToken
+CREATE TABLE user_email_tokens
+(
+  id            SERIAL primary key,
+  user_id       uuid not null references users(id) ON DELETE CASCADE,
+  purpose       text not null,
+  token_hash    text not null unique,         -- sha256 hex, the token itself is not stored
+  created_at    timestamp default timezone('utc', now()),
+  expires_at    timestamp not null,
+  used_at       timestamp,
+  CHECK (purpose IN ('verify_email', 'reset_password'))
+);
+
+CREATE INDEX user_email_tokens_user_id_idx ON user_email_tokens (user_id, purpose) WHERE used_at IS NULL;
+
+DROP TABLE IF EXISTS user_recovery_codes CASCADE;
+
+-- Single use codes to log in without the TOTP authenticator, replaced together
+CREATE TABLE user_recovery_codes
+(
+  id            SERIAL primary key,
+  user_id       uuid not null references users(id) ON DELETE CASCADE,
+  code_hash     text not null,                -- see auth.HashRecoveryCode
+  created_at    timestamp default timezone('utc', now()),
+  used_at       timestamp,
+  UNIQUE (user_id, code_hash)
+);
+
+DROP TABLE IF EXISTS user_mfa_challenges CASCADE;
+
+-- Second login step of users with two factor auth, the token is single use and invalid after
+-- auth.MaxMFAChallengeAttempts codes
+CREATE TABLE user_mfa_challenges
+(
+  id            SERIAL primary key,
+  user_id       uuid not null references users(id) ON DELETE CASCADE,
+  token_hash    text not null unique,
+  attempts      int not null default 0,      -- codes tried, counted before checking them
+  created_at    timestamp default timezone('utc', now()),
+  expires_at    timestamp not null,
+  used_at       timestamp
+);
+
+CREATE INDEX user_mfa_challenges_user_id_idx ON user_mfa_challenges (user_id, created_at);
@@ -0,0 +1,32 @@
+-- Moves the sign in method of each user from users.auth_type and fir_id into user_identities,
+-- for databases created before it, e.g. `make migrate DB=dealbasin MIGRATION=1_user_identities`
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS user_identities
+(
+  id            SERIAL primary key,
+  user_id       uuid not null references users(id) ON DELETE CASCADE,
+  provider      text not null,
+  subject       text,
+  email         citext,
+  linked_at     timestamp default timezone('utc', now()),
+  UNIQUE (provider, subject),
+  UNIQUE (user_id, provider),
+  CHECK (provider IN ('email', 'google', 'facebook'))
+);
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS auth_subject text;
+
+-- Firebase email sign in tokens have the Firebase user id as subject. Google and Facebook subjects
+-- are only known for registrations that verified their token, the others are matched by a verified email on the next login.
+INSERT INTO user_identities (user_id, provider, subject, email, linked_at)
+SELECT id, auth_type,
+  COALESCE(auth_subject, CASE WHEN auth_type = 'email' THEN NULLIF(fir_id, '') END),
+  email, COALESCE(created_at, timezone('utc', now()))
+FROM users
+WHERE auth_type IN ('email', 'google', 'facebook')
+ON CONFLICT DO NOTHING;
+
+ALTER TABLE users DROP COLUMN auth_subject;
+
+COMMIT;
@@ -0,0 +1,26 @@
+-- Passwords and email verification for databases created before them,
+-- e.g. `make migrate DB=dealbasin MIGRATION=2_password_auth`
+BEGIN;
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS password_hash text;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS email_verified_at timestamp;
+
+CREATE TABLE IF NOT EXISTS user_email_tokens
+(
+  id            SERIAL primary key,
+  user_id       uuid not null references users(id) ON DELETE CASCADE,
+  purpose       text not null,
+  token_hash    text not null unique,
+  created_at    timestamp default timezone('utc', now()),
+  expires_at    timestamp not null,
+  used_at       timestamp,
+  CHECK (purpose IN ('verify_email', 'reset_password'))
+);
+
+CREATE INDEX IF NOT EXISTS user_email_tokens_user_id_idx ON user_email_tokens (user_id, purpose) WHERE used_at IS NULL;
+
+-- Emails of Google and Facebook sign ins were verified by the provider
+UPDATE users SET email_verified_at = created_at
+WHERE email_verified_at ISNULL AND auth_type IN ('google', 'facebook');
+
+COMMIT;
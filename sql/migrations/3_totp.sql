@@ -0,0 +1,31 @@
+-- Two factor auth for databases created before it, e.g. `make migrate DB=dealbasin MIGRATION=3_totp`
+BEGIN;
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_secret text;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_enabled_at timestamp;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_last_step bigint;
+
+CREATE TABLE IF NOT EXISTS user_recovery_codes
+(
+  id            SERIAL primary key,
+  user_id       uuid not null references users(id) ON DELETE CASCADE,
+  code_hash     text not null,
+  created_at    timestamp default timezone('utc', now()),
+  used_at       timestamp,
+  UNIQUE (user_id, code_hash)
+);
+
+CREATE TABLE IF NOT EXISTS user_mfa_challenges
+(
+  id            SERIAL primary key,
+  user_id       uuid not null references users(id) ON DELETE CASCADE,
+  token_hash    text not null unique,
+  attempts      int not null default 0,
+  created_at    timestamp default timezone('utc', now()),
+  expires_at    timestamp not null,
+  used_at       timestamp
+);
+
+CREATE INDEX IF NOT EXISTS user_mfa_challenges_user_id_idx ON user_mfa_challenges (user_id, created_at);
+
+COMMIT;
@@ -0,0 +1,19 @@
+-- Profile bios and unique display names for databases created before them,
+-- e.g. `make migrate DB=dealbasin MIGRATION=4_user_profiles`
+BEGIN;
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS display_name_changed_at timestamp;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS bio text;
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_bio_check;
+ALTER TABLE users ADD CONSTRAINT users_bio_check CHECK (length(bio) <= 280);
+
+-- Later registrations of a taken name get the start of their id appended, within the 42 character limit
+UPDATE users u SET display_name = left(u.display_name, 33) || left(u.id::text, 8)
+FROM (
+  SELECT id, row_number() OVER (PARTITION BY lower(display_name) ORDER BY created_at, id) AS n FROM users
+) ranked
+WHERE ranked.id = u.id AND ranked.n > 1;
+
+CREATE UNIQUE INDEX IF NOT EXISTS users_unique_lower_display_name_idx ON users (lower(display_name));
+
+COMMIT;
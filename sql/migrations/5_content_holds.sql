@@ -0,0 +1,23 @@
+-- Screening holds in their own column, so that approving held content doesn't show content hidden by reports
+-- and dismissing reports doesn't show held content, e.g. `make migrate DB=dealbasin MIGRATION=5_content_holds`
+BEGIN;
+
+ALTER TABLE deals ADD COLUMN IF NOT EXISTS held_at timestamp;
+ALTER TABLE deal_comments ADD COLUMN IF NOT EXISTS held_at timestamp;
+
+-- unreviewed holds were kept in moderated_at, which stays set when open or hiding reports hid the content too
+UPDATE deals d SET held_at = d.moderated_at,
+  moderated_at = CASE WHEN EXISTS (SELECT 1 FROM content_reports c_r
+    WHERE c_r.target_type = 'deal' AND c_r.target_id = d.id
+    AND (c_r.resolved_at ISNULL OR c_r.resolution = 'hidden')) THEN d.moderated_at END
+WHERE d.moderated_at NOTNULL AND EXISTS (SELECT 1 FROM content_screenings c_s
+  WHERE c_s.target_type = 'deal' AND c_s.target_id = d.id AND c_s.verdict = 'hold' AND c_s.reviewed_at ISNULL);
+
+UPDATE deal_comments c SET held_at = c.moderated_at,
+  moderated_at = CASE WHEN EXISTS (SELECT 1 FROM content_reports c_r
+    WHERE c_r.target_type = 'comment' AND c_r.target_id = c.id
+    AND (c_r.resolved_at ISNULL OR c_r.resolution = 'hidden')) THEN c.moderated_at END
+WHERE c.moderated_at NOTNULL AND EXISTS (SELECT 1 FROM content_screenings c_s
+  WHERE c_s.target_type = 'comment' AND c_s.target_id = c.id AND c_s.verdict = 'hold' AND c_s.reviewed_at ISNULL);
+
+COMMIT;
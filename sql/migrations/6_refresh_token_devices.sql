@@ -0,0 +1,9 @@
+-- Devices of bearer clients, listed with the user's sessions, for databases created before them,
+-- e.g. `make migrate DB=dealbasin MIGRATION=6_refresh_token_devices`
+BEGIN;
+
+ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS device text not null default '';
+ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS user_agent text not null default '';
+ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS ip text not null default '';
+
+COMMIT;
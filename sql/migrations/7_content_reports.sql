@@ -0,0 +1,19 @@
+-- Reports can be made again once resolved, and user reports of the old users_reported table
+-- move to content_reports, e.g. `make migrate DB=dealbasin MIGRATION=7_content_reports`
+BEGIN;
+
+ALTER TABLE content_reports DROP CONSTRAINT IF EXISTS content_reports_reporter_id_target_type_target_id_key;
+CREATE UNIQUE INDEX IF NOT EXISTS content_reports_open_reporter_idx
+  ON content_reports (reporter_id, target_type, target_id) WHERE resolved_at IS NULL;
+
+-- the latest report of each reporter and user stays open for moderators
+INSERT INTO content_reports (reporter_id, target_type, target_id, reason_code, reason, created_at)
+SELECT DISTINCT ON (reporter_id, reported_id) reporter_id, 'user', reported_id, 'other', left(reason, 256), created_at
+FROM users_reported
+WHERE reported_id NOTNULL
+ORDER BY reporter_id, reported_id, created_at DESC
+ON CONFLICT DO NOTHING;
+
+DROP TABLE users_reported;
+
+COMMIT;